@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/yegorkir/jpgtools/internal/common"
 	"github.com/yegorkir/jpgtools/internal/compress"
 	"github.com/yegorkir/jpgtools/internal/overlay"
+	"github.com/yegorkir/jpgtools/internal/restore"
+	"github.com/yegorkir/jpgtools/internal/variants"
 )
 
 func main() {
@@ -17,12 +24,24 @@ func main() {
 	cmd := os.Args[1]
 	args := os.Args[2:]
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Restore default signal handling so a second Ctrl-C terminates immediately.
+		<-ctx.Done()
+		stop()
+	}()
+
 	var err error
 	switch cmd {
 	case "compress":
-		err = compress.Run(args)
+		err = compress.Run(ctx, args)
 	case "overlay":
-		err = overlay.Run(args)
+		err = overlay.Run(ctx, args)
+	case "variants":
+		err = variants.Run(ctx, args)
+	case "restore":
+		err = restore.Run(ctx, args)
 	case "help", "-h", "--help":
 		printUsage()
 		return
@@ -32,6 +51,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		os.Exit(130)
+	}
+	var exitErr *common.ExitError
+	if errors.As(err, &exitErr) {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(exitErr.Code)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -42,12 +70,21 @@ func printUsage() {
 	fmt.Print(`jpgtools — portable JPEG helper
 
 Usage:
-  jpgtools <command> [options]
+  jpgtools <command> [options] [files or globs...]
 
 Commands:
   compress   Recompress JPEGs to hit a target size, mirroring compress_jpgs.py.
   overlay    Apply a semi-transparent black overlay to every JPEG (apply_black_overlay.py).
+  variants   Produce several sized outputs per JPEG for responsive srcset images.
+  restore    Put back the originals saved by an --in-place run.
 
 Run "jpgtools <command> -h" for command-specific options.
+
+Exit codes:
+  0    all files processed
+  1    invalid usage or fatal error
+  2    some files stayed above the size target (with --fail-on maxed)
+  3    some files failed
+  130  interrupted
 `)
 }
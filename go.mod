@@ -1,3 +1,7 @@
 module github.com/yegorkir/jpgtools
 
 go 1.21.5
+
+require golang.org/x/image v0.14.0
+
+require golang.org/x/text v0.14.0 // indirect
@@ -0,0 +1,284 @@
+package common
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IsArchive reports whether path names a .zip, .tar, .tar.gz or .tgz file.
+func IsArchive(p string) bool {
+	return archiveKind(p) != ""
+}
+
+func archiveKind(p string) string {
+	lower := strings.ToLower(p)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"):
+		return "tar"
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tgz"
+	}
+	return ""
+}
+
+// archiveEntryPath turns an entry name into a safe relative path, rejecting
+// absolute names and anything that climbs out with "..".
+func archiveEntryPath(name string) (string, bool) {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if clean == "." || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return filepath.FromSlash(clean), true
+}
+
+// extractArchive unpacks the JPEG entries of src into dir, keeping their
+// relative paths and modification times so incremental runs work.
+func extractArchive(src, dir string) (int, error) {
+	if archiveKind(src) == "zip" {
+		return extractZip(src, dir)
+	}
+	return extractTar(src, dir)
+}
+
+func extractZip(src, dir string) (int, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	count := 0
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isJPEG(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return count, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		ok, err := extractEntry(dir, f.Name, rc, f.Modified)
+		rc.Close()
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func extractTar(src, dir string) (int, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if archiveKind(src) == "tgz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	count := 0
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isJPEG(hdr.Name) {
+			continue
+		}
+		ok, err := extractEntry(dir, hdr.Name, tr, hdr.ModTime)
+		if err != nil {
+			return count, err
+		}
+		if ok {
+			count++
+		}
+	}
+}
+
+func extractEntry(dir, name string, r io.Reader, modTime time.Time) (bool, error) {
+	rel, ok := archiveEntryPath(name)
+	if !ok {
+		fmt.Printf("[WARN] skipping archive entry %s: unsafe path\n", name)
+		return false, nil
+	}
+	dest := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return false, err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(dest, modTime, modTime); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// archiveWriter streams finished outputs into a .zip or .tar(.gz). It is
+// written under a temporary name and renamed into place on Close.
+// With path "-" each output is copied to stdout as is instead.
+type archiveWriter struct {
+	path   string
+	stdout io.Writer
+	file   *os.File
+	gz     *gzip.Writer
+	zip    *zip.Writer
+	tar    *tar.Writer
+	count  int
+}
+
+// createArchive returns a nil *archiveWriter when path is empty; all
+// methods accept it.
+func createArchive(p string, dryRun bool) (*archiveWriter, error) {
+	if p == "" || dryRun {
+		return nil, nil
+	}
+	if p == "-" {
+		return &archiveWriter{path: p, stdout: stdout}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return nil, err
+	}
+	a := &archiveWriter{path: p, file: f}
+	switch archiveKind(p) {
+	case "zip":
+		a.zip = zip.NewWriter(f)
+	case "tgz":
+		a.gz = gzip.NewWriter(f)
+		a.tar = tar.NewWriter(a.gz)
+	default:
+		a.tar = tar.NewWriter(f)
+	}
+	return a, nil
+}
+
+// drain moves every staged file under root, except the manifest, into the
+// archive.
+func (a *archiveWriter) drain(root string) error {
+	if a == nil {
+		return nil
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || p == filepath.Join(root, ManifestName) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if err := a.add(p, filepath.ToSlash(rel)); err != nil {
+			return fmt.Errorf("add %s to archive: %w", rel, err)
+		}
+		return os.Remove(p)
+	})
+}
+
+func (a *archiveWriter) add(p, name string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer
+	if a.stdout != nil {
+		w = a.stdout
+	} else if a.zip != nil {
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		// JPEG data does not deflate; storing keeps the archive fast to build.
+		hdr.Method = zip.Store
+		if w, err = a.zip.CreateHeader(hdr); err != nil {
+			return err
+		}
+	} else {
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := a.tar.WriteHeader(hdr); err != nil {
+			return err
+		}
+		w = a.tar
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return err
+	}
+	a.count++
+	return nil
+}
+
+func (a *archiveWriter) Close() error {
+	if a == nil || a.stdout != nil {
+		return nil
+	}
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+	}
+	if a.tar != nil {
+		err = a.tar.Close()
+	}
+	if a.gz != nil && err == nil {
+		err = a.gz.Close()
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(a.file.Name())
+		return err
+	}
+	if err := os.Rename(a.file.Name(), a.path); err != nil {
+		os.Remove(a.file.Name())
+		return err
+	}
+	fmt.Printf("Wrote %d files to %s.\n", a.count, a.path)
+	return nil
+}
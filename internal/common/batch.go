@@ -0,0 +1,314 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type ProcessFunc func(ctx context.Context, src, dest string) (FileResult, error)
+
+type Batch struct {
+	BatchOptions
+	OptionsHash string
+	// Collect rescans the input for --watch.
+	Collect func() ([]string, error)
+	// Naming, when set, keeps the outputs recorded in the manifest reserved.
+	Naming *Naming
+	// SourceOptions, when set, names settings picked per source, such as a
+	// mask file found for it, that belong in that source's options hash.
+	SourceOptions func(src string) string
+}
+
+// batchRun holds the state of a single Batch.Run invocation.
+type batchRun struct {
+	Batch
+	manifest *Manifest
+	report   *Report
+	summary  Summary
+	start    time.Time
+}
+
+func (b Batch) Run(ctx context.Context, files []string, process ProcessFunc) error {
+	manifest, err := OpenManifest(b.Output, b.DryRun)
+	if err != nil {
+		return fmt.Errorf("open manifest: %w", err)
+	}
+	defer manifest.Close()
+	if b.Naming != nil {
+		b.Naming.Claim(b.Input, manifest.Entries())
+	}
+
+	report, err := OpenReport(b.ReportPath, b.ReportFormat)
+	if err != nil {
+		return fmt.Errorf("open report: %w", err)
+	}
+	defer report.Close()
+
+	if b.archiveOut == "-" && len(files) != 1 {
+		return fmt.Errorf("--output - needs exactly one source, got %d", len(files))
+	}
+	archive, err := createArchive(b.archiveOut, b.DryRun)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+
+	r := &batchRun{Batch: b, manifest: manifest, report: report, start: time.Now()}
+	defer r.finish()
+	defer func() {
+		if err := archive.Close(); err != nil {
+			fmt.Printf("[ERROR] write %s: %v\n", b.archiveOut, err)
+		}
+	}()
+
+	var known map[string]fileState
+	if b.Watch {
+		known = snapshot(files)
+	}
+
+	processed := 0
+	seen := make(map[string]bool, len(files))
+	for _, src := range files {
+		seen[b.Key(src)] = true
+	}
+
+	for _, src := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		rel := b.relPath(src)
+		if err := r.processOne(ctx, src, rel, b.destFor(src, rel), process); err != nil {
+			break
+		}
+		if err := archive.drain(b.Output); err != nil {
+			return err
+		}
+		processed++
+	}
+
+	if err := ctx.Err(); err != nil {
+		fmt.Printf("Interrupted after %d of %d files in %s.\n", processed, len(files), time.Since(r.start).Truncate(time.Millisecond))
+		return err
+	}
+	if b.PruneOrphans {
+		r.pruneOrphans(seen)
+	}
+	fmt.Printf("Done in %s.\n", time.Since(r.start).Truncate(time.Millisecond))
+	if b.Watch {
+		if err := r.watch(ctx, known, process); err != nil {
+			return err
+		}
+	}
+	return r.summary.Outcome(b.FailOn)
+}
+
+// destFor is where process writes its result; in-place runs encode next to
+// the source and replace it afterwards.
+func (b Batch) destFor(src, rel string) string {
+	if b.InPlace {
+		return src + inPlaceSuffix
+	}
+	return filepath.Join(b.Output, rel)
+}
+
+func (b Batch) relPath(src string) string {
+	rel, ok := b.inInput(src)
+	// Files named outside --input land at the top of the output directory.
+	if !ok {
+		return filepath.Base(src)
+	}
+	return rel
+}
+
+// Key names src in the manifest. Files named outside --input are kept under
+// their absolute path so restore can find them and equal base names from
+// different directories stay apart.
+func (b Batch) Key(src string) string {
+	if rel, ok := b.inInput(src); ok {
+		return rel
+	}
+	if abs, err := filepath.Abs(src); err == nil {
+		return abs
+	}
+	return src
+}
+
+func (b Batch) inInput(src string) (string, bool) {
+	rel, err := filepath.Rel(b.Input, src)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (r *batchRun) finish() {
+	r.summary.Duration = time.Since(r.start)
+	r.summary.Print()
+	if err := r.report.Summary(r.summary); err != nil {
+		fmt.Printf("[WARN] write report: %v\n", err)
+	}
+}
+
+func (r *batchRun) record(res FileResult) {
+	r.summary.Add(res)
+	if err := r.report.Write(res); err != nil {
+		fmt.Printf("[WARN] write report: %v\n", err)
+	}
+}
+
+// processOne only returns an error when the batch has to stop.
+func (r *batchRun) processOne(ctx context.Context, src, rel, dest string, process ProcessFunc) error {
+	start := time.Now()
+	info, err := os.Stat(src)
+	if err != nil {
+		fmt.Printf("[ERROR] %s: %v\n", src, err)
+		r.record(FileResult{Source: src, Dest: dest, Status: "ERROR", Error: err.Error()})
+		return nil
+	}
+	key := r.Key(src)
+	optionsHash := r.OptionsHash
+	if r.SourceOptions != nil {
+		optionsHash = HashOptions(optionsHash, r.SourceOptions(src))
+	}
+	skipped := FileResult{Source: src, Dest: dest, Status: "SKIP", BytesIn: info.Size()}
+	if r.Incremental && r.manifest.Unchanged(key, info, optionsHash) {
+		fmt.Printf("[UNCHANGED] %s\n", src)
+		r.record(skipped)
+		return nil
+	}
+
+	srcHash, err := HashFile(src)
+	if err != nil {
+		fmt.Printf("[ERROR] %s: %v\n", src, err)
+		r.record(FileResult{Source: src, Dest: dest, Status: "ERROR", Error: err.Error()})
+		return nil
+	}
+	if (r.Resume || r.Incremental) && r.manifest.Completed(key, srcHash, optionsHash) {
+		if r.Incremental {
+			fmt.Printf("[UNCHANGED] %s\n", src)
+		} else {
+			fmt.Printf("[DONE] %s already completed (resume).\n", src)
+		}
+		if err := r.manifest.Touch(key, info); err != nil {
+			fmt.Printf("[WARN] %s: update manifest: %v\n", src, err)
+		}
+		r.record(skipped)
+		return nil
+	}
+
+	if r.InPlace {
+		defer os.Remove(dest)
+	}
+	res, err := process(ctx, src, dest)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			fmt.Printf("[CANCEL] %s\n", src)
+			return ctxErr
+		}
+		fmt.Printf("[ERROR] %s: %v\n", src, err)
+		res.Status = "ERROR"
+		res.Error = err.Error()
+	}
+	res.Source = src
+	switch r.archiveIn {
+	case "":
+	case "-":
+		res.Source = "-"
+	default:
+		res.Source = filepath.Join(r.archiveIn, rel)
+	}
+	if res.Dest == "" {
+		res.Dest = dest
+	}
+	res.BytesIn = info.Size()
+	if r.InPlace {
+		tmp := res.Dest
+		res.Dest = src
+		if err == nil && !r.DryRun && (res.Status == "OK" || res.Status == "MAXED") {
+			if err = r.replaceSource(src, key, tmp, info, &res); err != nil {
+				fmt.Printf("[ERROR] %s: %v\n", src, err)
+				res.Status = "ERROR"
+				res.Error = err.Error()
+			} else if replaced, statErr := os.Stat(src); statErr == nil {
+				// The result is now the source resume and incremental runs will see.
+				info = replaced
+				if sum, hashErr := HashFile(src); hashErr == nil {
+					srcHash = sum
+				}
+			}
+		}
+	}
+	res.Duration = time.Since(start)
+	recorded := res
+	if r.InPlace && err == nil && !r.DryRun && res.Status == "SKIP" {
+		// The source stays as it is; remember that so resume and incremental
+		// runs do not encode it again. An earlier backup is still its original.
+		recorded.Status = "KEPT"
+		recorded.Quality = 0
+		if prev, ok := r.manifest.Lookup(key); ok {
+			recorded.Backup = prev.Backup
+		}
+	}
+	if err := r.manifest.RecordResult(key, res.Dest, info, srcHash, optionsHash, recorded, err); err != nil {
+		fmt.Printf("[WARN] %s: update manifest: %v\n", src, err)
+	}
+	res.Dest = r.ShownDest(src, res.Dest)
+	r.record(res)
+	return nil
+}
+
+func (r *batchRun) pruneOrphans(seen map[string]bool) {
+	for _, e := range r.manifest.Entries() {
+		if seen[e.Source] || !e.done() {
+			continue
+		}
+		paths := e.paths()
+		// Never delete anything the manifest points at outside the output directory.
+		outside := false
+		for _, path := range paths {
+			if !within(r.Output, path) {
+				fmt.Printf("[WARN] not pruning %s: outside %s\n", path, r.Output)
+				outside = true
+			}
+		}
+		if outside {
+			continue
+		}
+		failed := false
+		for _, path := range paths {
+			if r.DryRun {
+				fmt.Printf("[DRY] prune %s (source %s is gone)\n", path, e.Source)
+				continue
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("[ERROR] prune %s: %v\n", path, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("[PRUNE] %s (source %s is gone)\n", path, e.Source)
+		}
+		if r.DryRun || failed {
+			continue
+		}
+		if err := r.manifest.Record(ManifestEntry{Source: e.Source, Dest: e.Dest, Status: "PRUNED"}); err != nil {
+			fmt.Printf("[WARN] %s: update manifest: %v\n", e.Dest, err)
+		}
+	}
+}
+
+func within(dir, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
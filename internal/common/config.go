@@ -0,0 +1,334 @@
+package common
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var configNames = []string{"jpgtools.yaml", "jpgtools.yml", "jpgtools.toml"}
+
+// Config maps command -> preset -> flag name -> raw flag value.
+type Config map[string]map[string]map[string]string
+
+// ConfigFlags registers --config, --preset and --print-config on fs.
+type ConfigFlags struct {
+	Path  *string
+	Name  *string
+	Print *bool
+}
+
+func RegisterConfigFlags(fs *flag.FlagSet) ConfigFlags {
+	return ConfigFlags{
+		Path:  fs.String("config", "", "Config file with presets (default: ./jpgtools.yaml, .yml or .toml if present)."),
+		Name:  fs.String("preset", "", "Named preset from the config file."),
+		Print: fs.Bool("print-config", false, "Print the effective options and exit."),
+	}
+}
+
+// Apply fills every flag the user did not set explicitly from the selected
+// preset. It reports whether --print-config was requested, in which case
+// the effective options have already been printed.
+func (c ConfigFlags) Apply(fs *flag.FlagSet, command string) (bool, error) {
+	if *c.Name != "" {
+		path, err := findConfig(*c.Path)
+		if err != nil {
+			return false, err
+		}
+		if path == "" {
+			return false, fmt.Errorf("--preset %s: no jpgtools.yaml/.toml found (use --config)", *c.Name)
+		}
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			return false, err
+		}
+		values, ok := cfg[command][*c.Name]
+		if !ok {
+			return false, fmt.Errorf("preset %q for %s not found in %s", *c.Name, command, path)
+		}
+		if err := applyValues(fs, values); err != nil {
+			return false, fmt.Errorf("preset %q: %w", *c.Name, err)
+		}
+	} else if *c.Path != "" {
+		if _, err := LoadConfig(*c.Path); err != nil {
+			return false, err
+		}
+	}
+
+	if *c.Print {
+		printFlags(fs, command)
+		return true, nil
+	}
+	return false, nil
+}
+
+func findConfig(explicit string) (string, error) {
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", err
+		}
+		return explicit, nil
+	}
+	for _, name := range configNames {
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+func applyValues(fs *flag.FlagSet, values map[string]string) error {
+	// Aliases such as -i/--input share one Value, so setting either counts.
+	explicit := make(map[string]bool)
+	fs.Visit(func(set *flag.Flag) {
+		fs.VisitAll(func(f *flag.Flag) {
+			if f.Value == set.Value {
+				explicit[f.Name] = true
+			}
+		})
+	})
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "config", "preset", "print-config":
+			return fmt.Errorf("%s cannot be set from a preset", key)
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("unknown option %q", key)
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := fs.Set(key, values[key]); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func printFlags(fs *flag.FlagSet, command string) {
+	fmt.Printf("[%s]\n", command)
+	fs.VisitAll(func(f *flag.Flag) {
+		// Skip one-letter aliases and the config flags themselves.
+		if len(f.Name) == 1 || f.Name == "config" || f.Name == "preset" || f.Name == "print-config" {
+			return
+		}
+		value := f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			if _, isString := g.Get().(string); isString {
+				value = strconv.Quote(value)
+			}
+		}
+		fmt.Printf("%s = %s\n", f.Name, value)
+	})
+}
+
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		cfg, err = parseTOML(lines)
+	case ".yaml", ".yml":
+		cfg, err = parseYAML(lines)
+	default:
+		return nil, fmt.Errorf("config %s: unsupported extension (want .yaml, .yml or .toml)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c Config) set(command, preset, key, value string) {
+	if c[command] == nil {
+		c[command] = make(map[string]map[string]string)
+	}
+	if c[command][preset] == nil {
+		c[command][preset] = make(map[string]string)
+	}
+	if key != "" {
+		c[command][preset][key] = value
+	}
+}
+
+// parseTOML understands the subset used for presets:
+//
+//	[compress.web-hero]
+//	target-kb = 300
+//	recursive = true
+func parseTOML(lines []string) (Config, error) {
+	cfg := make(Config)
+	var command, preset string
+	for i, raw := range lines {
+		line := strings.TrimSpace(stripComment(raw, false))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: malformed table header", i+1)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			cmd, p, ok := strings.Cut(name, ".")
+			if !ok || cmd == "" || p == "" {
+				return nil, fmt.Errorf("line %d: table must be [<command>.<preset>]", i+1)
+			}
+			command, preset = strings.TrimSpace(cmd), unquote(strings.TrimSpace(p))
+			cfg.set(command, preset, "", "")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		if command == "" {
+			return nil, fmt.Errorf("line %d: option outside of a [<command>.<preset>] table", i+1)
+		}
+		v, err := parseScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		cfg.set(command, preset, unquote(strings.TrimSpace(key)), v)
+	}
+	return cfg, nil
+}
+
+// parseYAML understands the subset used for presets:
+//
+//	compress:
+//	  web-hero:
+//	    target-kb: 300
+func parseYAML(lines []string) (Config, error) {
+	cfg := make(Config)
+	var command, preset string
+	var indents []int
+	for i, raw := range lines {
+		if strings.TrimSpace(raw) == "---" {
+			continue
+		}
+		stripped := stripComment(raw, true)
+		line := strings.TrimSpace(stripped)
+		if line == "" {
+			continue
+		}
+		lead := stripped[:len(stripped)-len(strings.TrimLeft(stripped, " \t"))]
+		if strings.Contains(lead, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			return nil, fmt.Errorf("line %d: lists are not supported", i+1)
+		}
+		indent := len(stripped) - len(strings.TrimLeft(stripped, " "))
+		for len(indents) > 0 && indents[len(indents)-1] >= indent {
+			indents = indents[:len(indents)-1]
+		}
+		indents = append(indents, indent)
+		depth := len(indents) - 1
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key = unquote(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch depth {
+		case 0:
+			if value != "" {
+				return nil, fmt.Errorf("line %d: %s must contain presets", i+1, key)
+			}
+			command, preset = key, ""
+		case 1:
+			if value != "" {
+				return nil, fmt.Errorf("line %d: preset %s must contain options", i+1, key)
+			}
+			preset = key
+			cfg.set(command, preset, "", "")
+		case 2:
+			if preset == "" {
+				return nil, fmt.Errorf("line %d: option outside of a preset", i+1)
+			}
+			v, err := parseScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			cfg.set(command, preset, key, v)
+		default:
+			return nil, fmt.Errorf("line %d: nesting deeper than command/preset/option", i+1)
+		}
+	}
+	return cfg, nil
+}
+
+func parseScalar(raw string) (string, error) {
+	v := strings.TrimSpace(raw)
+	if v == "" {
+		return "", fmt.Errorf("missing value")
+	}
+	if strings.HasPrefix(v, "[") || strings.HasPrefix(v, "{") {
+		return "", fmt.Errorf("arrays and inline tables are not supported")
+	}
+	return unquote(v), nil
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 {
+		switch {
+		case s[0] == '"' && s[len(s)-1] == '"':
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		case s[0] == '\'' && s[len(s)-1] == '\'':
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// stripComment drops a trailing "# ..." that is not inside quotes. YAML
+// only starts a comment at "#" preceded by whitespace (or at the start of
+// the line), so values like "#1a2b5c" survive when spaced is set.
+func stripComment(line string, spaced bool) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (!spaced || i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]string) (Config, error)
+		src   string
+		want  map[string]string
+	}{
+		{
+			name:  "yaml comments",
+			parse: parseYAML,
+			src: `# presets
+overlay:
+  dusk:  # evening look
+    alpha: 0.4 # trailing
+    text-color: "#1a2b5c"
+`,
+			want: map[string]string{"alpha": "0.4", "text-color": "#1a2b5c"},
+		},
+		{
+			name:  "yaml hash inside a list value",
+			parse: parseYAML,
+			src: `overlay:
+  dusk:
+    stop: 70%:#000000@0;100%:#1a2b5c@0.7
+`,
+			want: map[string]string{"stop": "70%:#000000@0;100%:#1a2b5c@0.7"},
+		},
+		{
+			name:  "yaml quoting",
+			parse: parseYAML,
+			src: `overlay:
+  "dusk":
+    text: "Shot #1 # not a comment" # comment
+    font: 'a b.ttf'
+`,
+			want: map[string]string{"text": "Shot #1 # not a comment", "font": "a b.ttf"},
+		},
+		{
+			name:  "toml comments and quoting",
+			parse: parseTOML,
+			src: `# presets
+[overlay.dusk] # evening look
+alpha = 0.4 # trailing
+text = "Shot #1"
+font = 'a b.ttf'
+`,
+			want: map[string]string{"alpha": "0.4", "text": "Shot #1", "font": "a b.ttf"},
+		},
+		{
+			name:  "toml list value",
+			parse: parseTOML,
+			src: `[overlay.dusk]
+stop = "70%:#000000@0;100%:#1a2b5c@0.7"
+`,
+			want: map[string]string{"stop": "70%:#000000@0;100%:#1a2b5c@0.7"},
+		},
+	}
+	for _, tt := range tests {
+		cfg, err := tt.parse(strings.Split(tt.src, "\n"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := cfg["overlay"]["dusk"]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListFlagSplitsPresetValues(t *testing.T) {
+	var l ListFlag
+	if err := l.Set(" 70%:#000000@0 ;; 100%:#1a2b5c@0.7"); err != nil {
+		t.Fatal(err)
+	}
+	want := ListFlag{"70%:#000000@0", "100%:#1a2b5c@0.7"}
+	if !reflect.DeepEqual(l, want) {
+		t.Errorf("got %q, want %q", l, want)
+	}
+}
@@ -4,11 +4,47 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
-func CollectJPEGs(root string, recursive bool) ([]string, error) {
+// CollectOptions filters and bounds the directory walk.
+type CollectOptions struct {
+	Recursive      bool
+	Include        []string
+	Exclude        []string
+	SkipHidden     bool
+	FollowSymlinks bool
+	// MaxDepth limits how many directory levels below root are walked; 0
+	// means no limit.
+	MaxDepth int
+}
+
+// Matches applies the include and exclude patterns to a slash-separated
+// path relative to the walk root.
+func (o CollectOptions) Matches(rel string) bool {
+	if len(o.Include) > 0 {
+		included := false
+		for _, p := range o.Include {
+			if matchGlob(p, rel) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, p := range o.Exclude {
+		if matchGlob(p, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+func CollectJPEGs(root string, opt CollectOptions) ([]string, error) {
 	info, err := os.Stat(root)
 	if err != nil {
 		return nil, err
@@ -17,36 +53,83 @@ func CollectJPEGs(root string, recursive bool) ([]string, error) {
 		return nil, fmt.Errorf("input %s is not a directory", root)
 	}
 
-	var files []string
-	if recursive {
-		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+	c := &collector{opt: opt, visited: make(map[string]bool)}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		c.visited[real] = true
+	}
+	err = c.walk(root, "", 0)
+	return c.files, err
+}
+
+type collector struct {
+	opt     CollectOptions
+	visited map[string]bool
+	files   []string
+}
+
+func (c *collector) walk(dir, rel string, depth int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if c.opt.SkipHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+		full := filepath.Join(dir, name)
+		entryRel := path.Join(rel, name)
+
+		isDir := entry.IsDir()
+		if entry.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Stat(full)
 			if err != nil {
-				return err
+				fmt.Printf("[WARN] skipping broken symlink %s\n", full)
+				continue
 			}
-			if d.IsDir() {
-				return nil
+			// Symlinked files are always taken; directories only when asked.
+			if target.IsDir() && !c.opt.FollowSymlinks {
+				continue
 			}
-			if isJPEG(path) {
-				files = append(files, path)
+			isDir = target.IsDir()
+		}
+
+		if !isDir {
+			if isJPEG(name) && c.opt.Matches(entryRel) {
+				c.files = append(c.files, full)
 			}
-			return nil
-		})
-	} else {
-		entries, err := os.ReadDir(root)
-		if err != nil {
-			return nil, err
-		}
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
+			continue
+		}
+		if !c.opt.Recursive || (c.opt.MaxDepth > 0 && depth >= c.opt.MaxDepth) || c.excluded(entryRel) {
+			continue
+		}
+		if c.opt.FollowSymlinks {
+			real, err := filepath.EvalSymlinks(full)
+			if err != nil {
+				return err
 			}
-			path := filepath.Join(root, entry.Name())
-			if isJPEG(path) {
-				files = append(files, path)
+			if c.visited[real] {
+				fmt.Printf("[WARN] skipping %s: symlink loop or directory already visited\n", full)
+				continue
 			}
+			c.visited[real] = true
+		}
+		if err := c.walk(full, entryRel, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// excluded prunes directories an exclude pattern covers, such as
+// "**/node_modules" or ".git/**", so they are never read.
+func (c *collector) excluded(rel string) bool {
+	for _, p := range c.opt.Exclude {
+		if matchGlob(p, rel) {
+			return true
 		}
 	}
-	return files, err
+	return false
 }
 
 func isJPEG(path string) bool {
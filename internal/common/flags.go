@@ -0,0 +1,275 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BatchOptions are the input/output and run-control flags shared by every
+// batch command.
+type BatchOptions struct {
+	Input          string
+	Output         string
+	Recursive      bool
+	Include        patternList
+	Exclude        patternList
+	SkipHidden     bool
+	FollowSymlinks bool
+	MaxDepth       int
+	Overwrite      bool
+	DryRun         bool
+	Resume         bool
+	Incremental    bool
+	PruneOrphans   bool
+	Watch          bool
+	PollInterval   time.Duration
+	Settle         time.Duration
+	ReportPath     string
+	ReportFormat   string
+	FailOn         string
+	FilesFrom      string
+	InPlace        bool
+	BackupDir      string
+	BackupSuffix   string
+
+	// Set when --input or --output is an archive or "-"; Input and Output then
+	// point at temporary directories removed by Cleanup.
+	archiveIn  string
+	archiveOut string
+	temp       []string
+	// files replaces the directory scan when sources were named explicitly.
+	files []string
+}
+
+// ListFlag collects a repeatable string flag; one value may also hold
+// several items separated by ";" so presets can list them on one line.
+type ListFlag []string
+
+func (l *ListFlag) String() string {
+	return strings.Join(*l, ";")
+}
+
+func (l *ListFlag) Set(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+func (l *ListFlag) Get() any {
+	return l.String()
+}
+
+func RegisterBatchFlags(fs *flag.FlagSet) *BatchOptions {
+	o := &BatchOptions{}
+	fs.StringVar(&o.Input, "input", ".", "Directory or .zip/.tar/.tar.gz archive with source JPEGs, or - for one image on stdin.")
+	fs.StringVar(&o.Input, "i", ".", "Directory or .zip/.tar/.tar.gz archive with source JPEGs, or - for one image on stdin.")
+	fs.StringVar(&o.Output, "output", "", "Destination directory, .zip/.tar/.tar.gz archive or - for stdout (default: ./output_YYMMDDhhmm).")
+	fs.StringVar(&o.Output, "o", "", "Destination directory, .zip/.tar/.tar.gz archive or - for stdout (default: ./output_YYMMDDhhmm).")
+	fs.BoolVar(&o.Recursive, "recursive", false, "Recurse into subdirectories.")
+	fs.Var(&o.Include, "include", "Only take files matching this glob relative to --input (repeatable, ** spans directories).")
+	fs.Var(&o.Exclude, "exclude", "Skip files and directories matching this glob relative to --input (repeatable).")
+	fs.BoolVar(&o.SkipHidden, "skip-hidden", false, "Skip files and directories whose names start with a dot.")
+	fs.BoolVar(&o.FollowSymlinks, "follow-symlinks", false, "Descend into symlinked directories, skipping loops.")
+	fs.IntVar(&o.MaxDepth, "max-depth", 0, "Walk at most this many directory levels below --input; implies --recursive (0: no limit).")
+	fs.BoolVar(&o.Overwrite, "overwrite", false, "Overwrite files in the output directory.")
+	fs.BoolVar(&o.DryRun, "dry-run", false, "Preview work without touching files.")
+	fs.BoolVar(&o.Resume, "resume", false, "Skip files the manifest records as completed with unchanged source and options.")
+	fs.BoolVar(&o.Incremental, "incremental", false, "Only process sources that are new or changed since the previous run.")
+	fs.BoolVar(&o.PruneOrphans, "prune-orphans", false, "With --incremental, delete outputs whose sources disappeared.")
+	fs.BoolVar(&o.Watch, "watch", false, "After the initial batch, keep watching --input for new or modified JPEGs.")
+	fs.DurationVar(&o.PollInterval, "watch-interval", 2*time.Second, "How often --watch rescans the input directory.")
+	fs.DurationVar(&o.Settle, "settle", 5*time.Second, "How long a file's size must stay unchanged before --watch processes it.")
+	fs.StringVar(&o.ReportPath, "report", "", "Write per-file results and a summary to this file.")
+	fs.StringVar(&o.ReportFormat, "format", "jsonl", "Report format: jsonl, csv or text.")
+	fs.StringVar(&o.FailOn, "fail-on", "error", "Exit non-zero when any file hits this status: error or maxed.")
+	fs.StringVar(&o.FilesFrom, "files-from", "", "Read source paths from this file (- for stdin), one per line or NUL-separated.")
+	return o
+}
+
+// RegisterInPlaceFlags adds --in-place and its backup options for commands
+// that produce exactly one output per source.
+func RegisterInPlaceFlags(fs *flag.FlagSet, o *BatchOptions) {
+	fs.BoolVar(&o.InPlace, "in-place", false, "Replace each source with its result once the result is encoded and verified.")
+	fs.StringVar(&o.BackupDir, "backup-dir", "", "With --in-place, keep originals in this directory, mirroring --input.")
+	fs.StringVar(&o.BackupSuffix, "backup-suffix", "", "With --in-place, keep originals next to the sources with this suffix (e.g. .orig).")
+}
+
+func (o *BatchOptions) Validate() error {
+	if o.MaxDepth < 0 {
+		return fmt.Errorf("max depth must not be negative")
+	}
+	if o.InPlace {
+		switch {
+		case o.Output != "":
+			return fmt.Errorf("--in-place cannot be combined with --output")
+		case o.Watch:
+			return fmt.Errorf("--in-place cannot be combined with --watch")
+		case o.BackupDir != "" && o.BackupSuffix != "":
+			return fmt.Errorf("use either --backup-dir or --backup-suffix, not both")
+		case o.BackupSuffix != "" && isJPEG("x"+o.BackupSuffix):
+			return fmt.Errorf("--backup-suffix %s would make backups look like sources", o.BackupSuffix)
+		}
+	} else if o.BackupDir != "" || o.BackupSuffix != "" {
+		return fmt.Errorf("--backup-dir and --backup-suffix require --in-place")
+	}
+	if o.Watch && o.FilesFrom != "" {
+		return fmt.Errorf("--watch rescans --input and cannot be combined with --files-from")
+	}
+	if IsArchive(o.Input) && (o.Watch || o.InPlace) {
+		return fmt.Errorf("an archive --input cannot be combined with --watch or --in-place")
+	}
+	if IsArchive(o.Output) && (o.Resume || o.Incremental || o.Watch) {
+		return fmt.Errorf("an archive --output cannot be combined with --resume, --incremental or --watch")
+	}
+	if o.Input == "-" && o.FilesFrom == "-" {
+		return fmt.Errorf("--input - and --files-from - both read stdin")
+	}
+	if o.Output == "-" && (o.Resume || o.Incremental || o.Watch || o.InPlace) {
+		return fmt.Errorf("--output - cannot be combined with --resume, --incremental, --watch or --in-place")
+	}
+
+	if o.PruneOrphans && !o.Incremental {
+		return fmt.Errorf("--prune-orphans requires --incremental")
+	}
+	if err := ValidateReportFormat(o.ReportFormat); err != nil {
+		return err
+	}
+	if err := ValidateFailPolicy(o.FailOn); err != nil {
+		return err
+	}
+	if o.PollInterval <= 0 {
+		return fmt.Errorf("watch interval must be positive")
+	}
+	if o.Settle < 0 {
+		return fmt.Errorf("settle time must not be negative")
+	}
+	return nil
+}
+
+// PrepareOutput resolves the default output directory and makes sure it
+// can be written to.
+func (o *BatchOptions) PrepareOutput() error {
+	if o.InPlace {
+		// The manifest lives next to the sources so restore can find it.
+		o.Output = o.Input
+		return nil
+	}
+	if o.Output == "-" || IsArchive(o.Output) {
+		if _, err := os.Stat(o.Output); err == nil && !o.Overwrite && !o.DryRun {
+			return fmt.Errorf("output archive %s already exists (use --overwrite)", o.Output)
+		}
+		// Results are staged here and moved into the archive one by one.
+		dir, err := os.MkdirTemp("", "jpgtools-output-")
+		if err != nil {
+			return err
+		}
+		o.temp = append(o.temp, dir)
+		o.archiveOut, o.Output = o.Output, dir
+		if o.archiveOut == "-" {
+			// Stdout now carries the image, so progress goes to stderr.
+			stdout = os.Stdout
+			os.Stdout = os.Stderr
+		}
+		return nil
+	}
+	out, err := ResolveOutputDir(o.Output)
+	if err != nil {
+		return err
+	}
+	o.Output = out
+	return EnsureOutputDir(out, o.Overwrite || o.Resume || o.Incremental, o.DryRun)
+}
+
+// Cleanup removes the temporary directories used for archives and stdin.
+func (o *BatchOptions) Cleanup() {
+	for _, dir := range o.temp {
+		os.RemoveAll(dir)
+	}
+}
+
+func (o *BatchOptions) Collect() ([]string, error) {
+	if o.files != nil {
+		return append([]string(nil), o.files...), nil
+	}
+	opt := o.collectOptions()
+	// Archive entries keep their folders, so they are always walked recursively.
+	opt.Recursive = opt.Recursive || o.archiveIn != ""
+	files, err := CollectJPEGs(o.Input, opt)
+	if err != nil || o.BackupDir == "" {
+		return files, err
+	}
+	// Backups kept inside --input must not be recompressed.
+	kept := files[:0]
+	for _, f := range files {
+		if !within(o.BackupDir, f) {
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
+
+func (o *BatchOptions) collectOptions() CollectOptions {
+	return CollectOptions{
+		Recursive:      o.Recursive || o.MaxDepth > 0,
+		Include:        o.Include.ListFlag,
+		Exclude:        o.Exclude.ListFlag,
+		SkipHidden:     o.SkipHidden,
+		FollowSymlinks: o.FollowSymlinks,
+		MaxDepth:       o.MaxDepth,
+	}
+}
+
+// NothingToDo reports whether an empty source list ends the run early.
+func (o *BatchOptions) NothingToDo(files []string) bool {
+	if len(files) > 0 || o.PruneOrphans || o.Watch {
+		return false
+	}
+	fmt.Printf("No JPEG files found in %s.\n", o.Input)
+	return true
+}
+
+// KeepExisting reports whether dest already exists and must be left alone.
+// Under --watch an existing output comes from this run, so a source that
+// changed since is reprocessed over it as with --incremental.
+func (o *BatchOptions) KeepExisting(dest string) (bool, error) {
+	_, err := os.Stat(dest)
+	if err == nil {
+		return !o.Overwrite && !o.Resume && !o.Incremental && !o.Watch && !o.DryRun && !o.InPlace, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// ShownDest is where a result written to dest ends up, for console messages
+// and the report. In-place results are staged next to the source they
+// replace, archive and stdout results in a temporary directory.
+func (o *BatchOptions) ShownDest(src, dest string) string {
+	switch {
+	case o.InPlace:
+		return src
+	case o.archiveOut == "-":
+		return "-"
+	case o.archiveOut != "":
+		if rel, err := filepath.Rel(o.Output, dest); err == nil {
+			return filepath.Join(o.archiveOut, rel)
+		}
+	}
+	return dest
+}
+
+func (o *BatchOptions) Batch(optionsHash string) Batch {
+	return Batch{
+		BatchOptions: *o,
+		OptionsHash:  optionsHash,
+		Collect:      o.Collect,
+	}
+}
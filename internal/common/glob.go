@@ -0,0 +1,113 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// patternList is the --include/--exclude ListFlag; it rejects malformed
+// globs while parsing.
+type patternList struct {
+	ListFlag
+}
+
+func (l *patternList) Set(value string) error {
+	var parts ListFlag
+	if err := parts.Set(value); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := validateGlob(p); err != nil {
+			return err
+		}
+	}
+	l.ListFlag = append(l.ListFlag, parts...)
+	return nil
+}
+
+func validateGlob(pattern string) error {
+	for _, alt := range expandBraces(pattern) {
+		for _, seg := range strings.Split(alt, "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("bad glob %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash-separated name matches pattern using
+// doublestar rules: "**" spans any number of path segments, "*", "?" and
+// "[...]" stay within one segment and "{a,b}" lists alternatives.
+func matchGlob(pattern, name string) bool {
+	parts := strings.Split(name, "/")
+	for _, alt := range expandBraces(pattern) {
+		if matchSegments(strings.Split(alt, "/"), parts) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// expandBraces turns "a.{jpg,jpeg}" into "a.jpg" and "a.jpeg"; nested and
+// repeated groups are expanded left to right.
+func expandBraces(pattern string) []string {
+	open := strings.IndexByte(pattern, '{')
+	if open < 0 {
+		return []string{pattern}
+	}
+	depth, close := 0, -1
+	var commas []int
+	for i := open; i < len(pattern) && close < 0; i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				close = i
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	if close < 0 {
+		return []string{pattern}
+	}
+
+	var out []string
+	start := open + 1
+	for _, end := range append(commas, close) {
+		for _, rest := range expandBraces(pattern[close+1:]) {
+			for _, alt := range expandBraces(pattern[start:end]) {
+				out = append(out, pattern[:open]+alt+rest)
+			}
+		}
+		start = end + 1
+	}
+	return out
+}
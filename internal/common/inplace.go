@@ -0,0 +1,110 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/yegorkir/jpgtools/internal/imageutil"
+)
+
+// inPlaceSuffix marks results waiting to replace their source. It is not a
+// JPEG extension, so leftovers are never collected as sources.
+const inPlaceSuffix = ".jpgtools-tmp"
+
+// replaceSource verifies the result at tmp, saves the original and renames
+// the result over the source, so the source is either untouched or fully
+// replaced.
+func (r *batchRun) replaceSource(src, key, tmp string, info fs.FileInfo, res *FileResult) error {
+	if err := imageutil.VerifyJPEG(tmp, res.Processed); err != nil {
+		return fmt.Errorf("verify result: %w", err)
+	}
+	var backup string
+	switch {
+	case r.BackupDir != "":
+		// Absolute keys of sources outside --input nest below the directory.
+		backup = filepath.Join(r.BackupDir, key)
+	case r.BackupSuffix != "":
+		backup = src + r.BackupSuffix
+	}
+	if backup != "" {
+		if err := backupFile(src, backup); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, src); err != nil {
+		return err
+	}
+
+	if backup == "" {
+		fmt.Printf("[REPLACED] %s\n", src)
+		return nil
+	}
+	fmt.Printf("[REPLACED] %s (original kept in %s)\n", src, backup)
+	// Restore resolves relative backups against the manifest directory.
+	if rel, err := filepath.Rel(r.Output, backup); err == nil && within(r.Output, backup) {
+		res.Backup = rel
+	} else if abs, err := filepath.Abs(backup); err == nil {
+		res.Backup = abs
+	}
+	return nil
+}
+
+func backupFile(src, backup string) error {
+	// A backup from an earlier run holds the real original; a file that was
+	// already processed in place must never replace it.
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(backup), 0o755); err != nil {
+		return err
+	}
+	if err := os.Link(src, backup); err == nil {
+		return nil
+	}
+	return CopyFile(src, backup)
+}
+
+// CopyFile copies src through a temporary file so dst never ends up
+// half-written.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + inPlaceSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
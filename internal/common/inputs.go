@@ -0,0 +1,169 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stdout is the real standard output once --output - has pointed os.Stdout
+// at stderr for progress messages.
+var stdout *os.File
+
+// PrepareInput resolves where sources come from: positional paths and
+// globs, --files-from, an image on stdin (--input -) or an archive.
+func (o *BatchOptions) PrepareInput(args []string) error {
+	if o.Input == "-" {
+		if len(args) > 0 || o.FilesFrom != "" {
+			return fmt.Errorf("--input - cannot be combined with file arguments or --files-from")
+		}
+		return o.readStdinImage()
+	}
+	if len(args) > 0 || o.FilesFrom != "" {
+		if IsArchive(o.Input) {
+			return fmt.Errorf("file arguments and --files-from cannot be combined with an archive --input")
+		}
+		if o.Watch {
+			return fmt.Errorf("--watch rescans --input and cannot be combined with file arguments")
+		}
+		files, err := o.expandArgs(args)
+		if err != nil {
+			return err
+		}
+		o.files = files
+		return nil
+	}
+	if !IsArchive(o.Input) {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "jpgtools-input-")
+	if err != nil {
+		return err
+	}
+	o.temp = append(o.temp, dir)
+	n, err := extractArchive(o.Input, dir)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", o.Input, err)
+	}
+	fmt.Printf("Extracted %d JPEGs from %s.\n", n, o.Input)
+	o.archiveIn, o.Input = o.Input, dir
+	return nil
+}
+
+func (o *BatchOptions) readStdinImage() error {
+	dir, err := os.MkdirTemp("", "jpgtools-stdin-")
+	if err != nil {
+		return err
+	}
+	o.temp = append(o.temp, dir)
+	path := filepath.Join(dir, "stdin.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		f.Close()
+		return fmt.Errorf("read stdin: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	o.archiveIn, o.Input, o.files = "-", dir, []string{path}
+	return nil
+}
+
+// expandArgs turns positional arguments and the --files-from list into
+// source paths. Globs are expanded here so they also work on Windows, and
+// directories contribute the JPEGs they contain.
+func (o *BatchOptions) expandArgs(args []string) ([]string, error) {
+	names := append([]string(nil), args...)
+	if o.FilesFrom != "" {
+		listed, err := readFileList(o.FilesFrom)
+		if err != nil {
+			return nil, fmt.Errorf("--files-from: %w", err)
+		}
+		names = append(names, listed...)
+	}
+
+	opt := o.collectOptions()
+	files := []string{}
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, name := range names {
+		matches := []string{name}
+		if strings.ContainsAny(name, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(name); err != nil {
+				return nil, fmt.Errorf("pattern %s: %w", name, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", name)
+			}
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if opt.Matches(o.filterPath(path)) {
+					add(filepath.Clean(path))
+				}
+				continue
+			}
+			found, err := CollectJPEGs(path, opt)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range found {
+				add(f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// filterPath is the path --include and --exclude see for a named file:
+// relative to --input when it lies inside, as given otherwise.
+func (o *BatchOptions) filterPath(path string) string {
+	if within(o.Input, path) {
+		if rel, err := filepath.Rel(o.Input, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// readFileList reads paths separated by NUL bytes (find -print0) or, when
+// there are none, by newlines.
+func readFileList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var names []string
+	for _, name := range strings.Split(string(data), sep) {
+		if name = strings.TrimRight(name, "\r"); strings.TrimSpace(name) != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,251 @@
+package common
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const ManifestName = "jpgtools-manifest.jsonl"
+
+type ManifestEntry struct {
+	Source        string    `json:"source"`
+	Dest          string    `json:"dest"`
+	Outputs       []string  `json:"outputs,omitempty"`
+	Backup        string    `json:"backup,omitempty"`
+	SourceSize    int64     `json:"source_size,omitempty"`
+	SourceModTime time.Time `json:"source_mtime,omitempty"`
+	SourceHash    string    `json:"source_hash"`
+	OptionsHash   string    `json:"options_hash"`
+	Quality       int       `json:"quality,omitempty"`
+	Size          int64     `json:"size,omitempty"`
+	OutputHash    string    `json:"output_hash,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// done reports a finished source. KEPT marks an in-place source whose
+// result was not smaller, so the original stayed and is its own output.
+func (e ManifestEntry) done() bool {
+	return e.Status == "OK" || e.Status == "MAXED" || e.Status == "KEPT"
+}
+
+// Manifest is an append-only JSON Lines log kept in the output directory.
+// Later lines for the same source replace earlier ones, so a run that dies
+// halfway leaves every completed file recorded.
+type Manifest struct {
+	path    string
+	entries map[string]ManifestEntry
+	file    *os.File
+}
+
+func OpenManifest(dir string, dryRun bool) (*Manifest, error) {
+	m := &Manifest{
+		path:    filepath.Join(dir, ManifestName),
+		entries: make(map[string]ManifestEntry),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return m, nil
+	}
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	m.file = f
+	return m, nil
+}
+
+func (m *Manifest) load() error {
+	f, err := os.Open(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var e ManifestEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// A run killed mid-write can leave a torn last line; ignore it.
+			continue
+		}
+		m.entries[e.Source] = e
+	}
+	return sc.Err()
+}
+
+func (m *Manifest) Lookup(source string) (ManifestEntry, bool) {
+	e, ok := m.entries[source]
+	return e, ok
+}
+
+func (m *Manifest) Record(e ManifestEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	m.entries[e.Source] = e
+	if m.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = m.file.Write(append(line, '\n'))
+	return err
+}
+
+// Completed reports whether source was finished by an earlier run with the
+// same content and options, and its output is still intact on disk.
+func (m *Manifest) Completed(source, sourceHash, optionsHash string) bool {
+	e, ok := m.entries[source]
+	if !ok || !e.done() {
+		return false
+	}
+	if e.SourceHash != sourceHash || e.OptionsHash != optionsHash {
+		return false
+	}
+	info, err := os.Stat(e.Dest)
+	if err != nil || info.Size() != e.Size || !e.outputsExist() {
+		return false
+	}
+	sum, err := HashFile(e.Dest)
+	return err == nil && sum == e.OutputHash
+}
+
+// Unchanged is the cheap check used by incremental runs: it trusts size and
+// modification time instead of rehashing the source.
+func (m *Manifest) Unchanged(source string, info fs.FileInfo, optionsHash string) bool {
+	e, ok := m.entries[source]
+	if !ok || !e.done() || e.OptionsHash != optionsHash {
+		return false
+	}
+	if e.SourceSize != info.Size() || !e.SourceModTime.Equal(info.ModTime()) {
+		return false
+	}
+	_, err := os.Stat(e.Dest)
+	return err == nil && e.outputsExist()
+}
+
+// paths lists every file the entry owns: Dest and any further Outputs.
+func (e ManifestEntry) paths() []string {
+	paths := []string{e.Dest}
+	for _, out := range e.Outputs {
+		if out != e.Dest {
+			paths = append(paths, out)
+		}
+	}
+	return paths
+}
+
+func (e ManifestEntry) outputsExist() bool {
+	for _, path := range e.Outputs {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Touch refreshes the recorded size and modification time of a source whose
+// content turned out to be unchanged, so the next incremental run stays cheap.
+func (m *Manifest) Touch(source string, info fs.FileInfo) error {
+	e, ok := m.entries[source]
+	if !ok {
+		return nil
+	}
+	e.SourceSize = info.Size()
+	e.SourceModTime = info.ModTime()
+	e.Time = time.Time{}
+	return m.Record(e)
+}
+
+func (m *Manifest) Entries() []ManifestEntry {
+	entries := make([]ManifestEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Source < entries[j].Source })
+	return entries
+}
+
+func (m *Manifest) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	return m.file.Close()
+}
+
+func (m *Manifest) RecordResult(source, dest string, info fs.FileInfo, sourceHash, optionsHash string, res FileResult, procErr error) error {
+	e := ManifestEntry{
+		Source:        source,
+		Dest:          dest,
+		Backup:        res.Backup,
+		SourceSize:    info.Size(),
+		SourceModTime: info.ModTime(),
+		SourceHash:    sourceHash,
+		OptionsHash:   optionsHash,
+		Status:        res.Status,
+	}
+	switch {
+	case procErr != nil:
+		e.Status = "ERROR"
+		e.Error = procErr.Error()
+	case res.Status == "OK" || res.Status == "MAXED" || res.Status == "KEPT":
+		out, err := os.Stat(dest)
+		if err != nil {
+			return err
+		}
+		sum, err := HashFile(dest)
+		if err != nil {
+			return err
+		}
+		// Size and hash describe dest alone; BytesOut may cover several outputs.
+		e.Outputs = res.Outputs
+		e.Quality = res.Quality
+		e.Size = out.Size()
+		e.OutputHash = sum
+	default:
+		return nil
+	}
+	return m.Record(e)
+}
+
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func HashOptions(parts ...any) string {
+	h := sha256.New()
+	for _, p := range parts {
+		fmt.Fprintf(h, "%#v;", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,235 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yegorkir/jpgtools/internal/imageutil"
+)
+
+// ExpandTemplate replaces {key} placeholders with values from vars.
+// Unknown or unterminated placeholders are reported as errors.
+func ExpandTemplate(tmpl string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			b.WriteString(tmpl)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", tmpl)
+		}
+		key := tmpl[start+1 : start+end]
+		value, ok := vars[key]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder {%s}", key)
+		}
+		b.WriteString(tmpl[:start])
+		b.WriteString(value)
+		tmpl = tmpl[start+end+1:]
+	}
+}
+
+// CheckTemplate validates tmpl against the allowed placeholder names.
+func CheckTemplate(tmpl string, allowed []string) error {
+	vars := make(map[string]string, len(allowed))
+	for _, k := range allowed {
+		vars[k] = "x"
+	}
+	_, err := ExpandTemplate(tmpl, vars)
+	return err
+}
+
+var nameKeys = []string{"dir", "name", "ext", "width", "height", "quality", "hash8", "date"}
+
+// Naming maps sources onto output paths through --name-template and
+// --flatten, keeping names unique within a run.
+type Naming struct {
+	Template string
+	Flatten  bool
+	extra    []string
+	def      string
+	used     map[string]string
+}
+
+// NameVars carries the values only known once the image has been decoded
+// or encoded; zero values leave their placeholders unresolved.
+type NameVars struct {
+	Width   int
+	Height  int
+	Quality int
+	Extra   map[string]string
+}
+
+func RegisterNamingFlags(fs *flag.FlagSet, defaultTemplate string, extra ...string) *Naming {
+	n := &Naming{extra: extra, def: defaultTemplate}
+	keys := make([]string, 0, len(nameKeys)+len(extra))
+	for _, k := range append(append([]string{}, nameKeys...), extra...) {
+		keys = append(keys, "{"+k+"}")
+	}
+	fs.StringVar(&n.Template, "name-template", defaultTemplate, "Output path relative to --output; placeholders: "+strings.Join(keys, ", ")+".")
+	fs.BoolVar(&n.Flatten, "flatten", false, "Write every output directly into --output, numbering names that collide.")
+	return n
+}
+
+func (n *Naming) Validate() error {
+	if n.Flatten && n.Template == n.def {
+		n.Template = strings.TrimPrefix(n.def, "{dir}/")
+	}
+	if err := CheckTemplate(n.Template, append(append([]string{}, nameKeys...), n.extra...)); err != nil {
+		return fmt.Errorf("name template: %w", err)
+	}
+	return nil
+}
+
+// Mirrors reports whether outputs keep the source layout unchanged.
+func (n *Naming) Mirrors() bool {
+	return !n.Flatten && n.Template == n.def
+}
+
+func (n *Naming) Uses(key string) bool {
+	return strings.Contains(n.Template, "{"+key+"}")
+}
+
+// Resolve returns the output path for src, where dest is the default
+// mirrored path under output. The path is only final, and only reserved
+// against collisions, when the second result is true.
+func (n *Naming) Resolve(output, src, dest string, v NameVars) (string, bool, error) {
+	rel, err := filepath.Rel(output, dest)
+	if err != nil {
+		rel = filepath.Base(src)
+	}
+	dir := filepath.ToSlash(filepath.Dir(rel))
+	if dir == "." {
+		dir = ""
+	}
+	if n.Flatten {
+		dir = strings.ReplaceAll(dir, "/", "_")
+	}
+	base := filepath.Base(rel)
+	ext := filepath.Ext(base)
+	vars := map[string]string{
+		"dir":  dir,
+		"name": strings.TrimSuffix(base, ext),
+		"ext":  strings.TrimPrefix(ext, "."),
+	}
+
+	complete := true
+	for key, value := range map[string]int{"width": v.Width, "height": v.Height, "quality": v.Quality} {
+		if value > 0 {
+			vars[key] = strconv.Itoa(value)
+			continue
+		}
+		vars[key] = "{" + key + "}"
+		if n.Uses(key) {
+			complete = false
+		}
+	}
+	if n.Uses("hash8") {
+		sum, err := HashFile(src)
+		if err != nil {
+			return "", false, err
+		}
+		vars["hash8"] = sum[:8]
+	}
+	if n.Uses("date") {
+		taken, ok := imageutil.ReadDateTaken(src)
+		if !ok {
+			info, err := os.Stat(src)
+			if err != nil {
+				return "", false, err
+			}
+			taken = info.ModTime()
+		}
+		vars["date"] = taken.Format("2006-01-02")
+	}
+	for k, value := range v.Extra {
+		vars[k] = value
+	}
+
+	name, err := ExpandTemplate(n.Template, vars)
+	if err != nil {
+		return "", false, err
+	}
+	name = filepath.FromSlash(strings.TrimLeft(name, "/"))
+	if n.Flatten {
+		name = strings.ReplaceAll(name, string(filepath.Separator), "_")
+	}
+	if name == "" {
+		return "", false, fmt.Errorf("name template produced an empty name for %s", src)
+	}
+	path := filepath.Join(output, name)
+	if !within(output, path) || filepath.Clean(path) == filepath.Clean(output) {
+		return "", false, fmt.Errorf("name template puts %s outside %s", src, output)
+	}
+	if !complete {
+		return path, false, nil
+	}
+	return n.reserve(path, src), true, nil
+}
+
+// Claim reserves the outputs an earlier run recorded in the manifest, so a
+// source skipped by --resume or --incremental keeps its name and a changed
+// source is not numbered onto it.
+func (n *Naming) Claim(input string, entries []ManifestEntry) {
+	for _, e := range entries {
+		if !e.done() || e.Dest == "" {
+			continue
+		}
+		src := e.Source
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(input, src)
+		}
+		n.claim(e.Dest, src)
+		for _, out := range e.Outputs {
+			n.claim(out, src)
+		}
+	}
+}
+
+func (n *Naming) claim(path, src string) {
+	if n.used == nil {
+		n.used = make(map[string]string)
+	}
+	n.used[absPath(path)] = absPath(src)
+}
+
+func (n *Naming) reserve(path, src string) string {
+	ext := filepath.Ext(path)
+	candidate := path
+	for i := 2; ; i++ {
+		owner, taken := n.used[absPath(candidate)]
+		if !taken || owner == absPath(src) {
+			n.claim(candidate, src)
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i, ext)
+	}
+}
+
+// absPath makes paths comparable however --input and --output were spelled.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// Provisional is where an output is encoded while its name still waits for
+// {quality}; Place moves it to the final path afterwards.
+func (n *Naming) Provisional(output, src string) string {
+	return filepath.Join(output, "."+filepath.Base(src)+".pending")
+}
+
+func (n *Naming) Place(provisional, final string) error {
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(provisional, final)
+}
@@ -0,0 +1,246 @@
+package common
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var reportFormats = []string{"jsonl", "csv", "text"}
+
+type Report struct {
+	format string
+	file   *os.File
+	w      io.Writer
+	csv    *csv.Writer
+}
+
+type fileRecord struct {
+	Type       string   `json:"type"`
+	Source     string   `json:"source"`
+	Dest       string   `json:"dest"`
+	Status     string   `json:"status"`
+	Original   [2]int   `json:"original"`
+	Processed  [2]int   `json:"processed"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Crop       []int    `json:"crop,omitempty"`
+	Sample     string   `json:"sample,omitempty"`
+	QuantTable string   `json:"quant_table,omitempty"`
+	SSIM       float64  `json:"ssim,omitempty"`
+	Quality    int      `json:"quality,omitempty"`
+	Attempts   int      `json:"attempts,omitempty"`
+	BytesIn    int64    `json:"bytes_in"`
+	BytesOut   int64    `json:"bytes_out"`
+	DurationMS float64  `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type summaryRecord struct {
+	Type       string         `json:"type"`
+	Files      int            `json:"files"`
+	Counts     map[string]int `json:"counts"`
+	BytesIn    int64          `json:"bytes_in"`
+	BytesOut   int64          `json:"bytes_out"`
+	DurationMS float64        `json:"duration_ms"`
+}
+
+var csvHeader = []string{
+	"type", "source", "dest", "status",
+	"original_width", "original_height", "processed_width", "processed_height",
+	"warnings", "quality", "attempts", "bytes_in", "bytes_out", "duration_ms", "error", "crop",
+	"sample", "quant_table", "ssim",
+}
+
+func ValidateReportFormat(format string) error {
+	for _, f := range reportFormats {
+		if format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown report format %q (want %s)", format, strings.Join(reportFormats, ", "))
+}
+
+// OpenReport returns a nil *Report when path is empty; all methods accept it.
+func OpenReport(path, format string) (*Report, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if err := ValidateReportFormat(format); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{format: format, file: f, w: f}
+	if format == "csv" {
+		r.csv = csv.NewWriter(r.w)
+		if err := r.csv.Write(csvHeader); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *Report) Write(res FileResult) error {
+	if r == nil {
+		return nil
+	}
+	ms := durationMS(res.Duration)
+	switch r.format {
+	case "jsonl":
+		return r.writeJSON(fileRecord{
+			Type:       "file",
+			Source:     res.Source,
+			Dest:       res.Dest,
+			Status:     res.Status,
+			Original:   res.Original,
+			Processed:  res.Processed,
+			Warnings:   res.Warnings,
+			Crop:       res.Crop,
+			Sample:     res.Sample,
+			QuantTable: res.QuantTable,
+			SSIM:       res.SSIM,
+			Quality:    res.Quality,
+			Attempts:   res.Attempts,
+			BytesIn:    res.BytesIn,
+			BytesOut:   res.BytesOut,
+			DurationMS: ms,
+			Error:      res.Error,
+		})
+	case "csv":
+		return r.writeCSV([]string{
+			"file", res.Source, res.Dest, res.Status,
+			strconv.Itoa(res.Original[0]), strconv.Itoa(res.Original[1]),
+			strconv.Itoa(res.Processed[0]), strconv.Itoa(res.Processed[1]),
+			strings.Join(res.Warnings, "; "),
+			strconv.Itoa(res.Quality), strconv.Itoa(res.Attempts),
+			strconv.FormatInt(res.BytesIn, 10), strconv.FormatInt(res.BytesOut, 10),
+			strconv.FormatFloat(ms, 'f', 1, 64), res.Error, formatCrop(res.Crop),
+			res.Sample, res.QuantTable, formatSSIM(res.SSIM),
+		})
+	default:
+		line := fmt.Sprintf("%-6s %s -> %s %dx%d->%dx%d q=%d attempts=%d in=%d out=%d %.1fms",
+			res.Status, res.Source, res.Dest,
+			res.Original[0], res.Original[1], res.Processed[0], res.Processed[1],
+			res.Quality, res.Attempts, res.BytesIn, res.BytesOut, ms)
+		if len(res.Warnings) > 0 {
+			line += " (" + strings.Join(res.Warnings, ", ") + ")"
+		}
+		if res.Crop != nil {
+			line += " crop=" + formatCrop(res.Crop)
+		}
+		if res.Sample != "" {
+			line += fmt.Sprintf(" sample=%s quant-table=%s ssim=%s", res.Sample, res.QuantTable, formatSSIM(res.SSIM))
+		}
+		if res.Error != "" {
+			line += " error: " + res.Error
+		}
+		_, err := fmt.Fprintln(r.w, line)
+		return err
+	}
+}
+
+func (r *Report) Summary(s Summary) error {
+	if r == nil {
+		return nil
+	}
+	ms := durationMS(s.Duration)
+	switch r.format {
+	case "jsonl":
+		counts := s.Counts
+		if counts == nil {
+			counts = map[string]int{}
+		}
+		return r.writeJSON(summaryRecord{
+			Type:       "summary",
+			Files:      s.Files,
+			Counts:     counts,
+			BytesIn:    s.BytesIn,
+			BytesOut:   s.BytesOut,
+			DurationMS: ms,
+		})
+	case "csv":
+		// The summary row reuses the file columns; status carries the counts.
+		return r.writeCSV([]string{
+			"summary", "", "", fmt.Sprintf("files=%d;%s", s.Files, formatCounts(s.Counts, ";")),
+			"", "", "", "", "", "", "",
+			strconv.FormatInt(s.BytesIn, 10), strconv.FormatInt(s.BytesOut, 10),
+			strconv.FormatFloat(ms, 'f', 1, 64), "", "",
+			"", "", "",
+		})
+	default:
+		_, err := fmt.Fprintf(r.w, "SUMMARY files=%d %s in=%d out=%d %.1fms\n",
+			s.Files, formatCounts(s.Counts, " "), s.BytesIn, s.BytesOut, ms)
+		return err
+	}
+}
+
+func (r *Report) Close() error {
+	if r == nil {
+		return nil
+	}
+	if r.csv != nil {
+		r.csv.Flush()
+		if err := r.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return r.file.Close()
+}
+
+func (r *Report) writeJSON(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Report) writeCSV(row []string) error {
+	if err := r.csv.Write(row); err != nil {
+		return err
+	}
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+// formatCrop writes x,y,w,h as WxH+X+Y, the ImageMagick geometry syntax.
+func formatCrop(crop []int) string {
+	if len(crop) != 4 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d+%d+%d", crop[2], crop[3], crop[0], crop[1])
+}
+
+func formatSSIM(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func formatCounts(counts map[string]int, sep string) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", strings.ToLower(k), counts[k]))
+	}
+	return strings.Join(parts, sep)
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ExitMaxed  = 2
+	ExitFailed = 3
+)
+
+var failPolicies = []string{"error", "maxed"}
+
+// ExitError carries the process exit code for a batch that finished but
+// did not meet the --fail-on policy.
+type ExitError struct {
+	Code int
+	Msg  string
+}
+
+func (e *ExitError) Error() string {
+	return e.Msg
+}
+
+func ValidateFailPolicy(policy string) error {
+	for _, p := range failPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown --fail-on policy %q (want %s)", policy, strings.Join(failPolicies, ", "))
+}
+
+type FileResult struct {
+	Source    string
+	Dest      string
+	Backup    string
+	Status    string
+	Original  [2]int
+	Processed [2]int
+	Warnings  []string
+	// Crop is x, y, width, height of the source area kept by a cropping fit,
+	// reported with --debug-crop.
+	Crop []int
+	// Outputs lists every file written for the source when there are
+	// several, as with variants; Dest is then the largest of them.
+	Outputs []string
+	// Sample, QuantTable and SSIM record the winning combination of the
+	// grid search strategy.
+	Sample     string
+	QuantTable string
+	SSIM       float64
+	Quality    int
+	Attempts   int
+	BytesIn    int64
+	BytesOut   int64
+	Duration   time.Duration
+	Error      string
+}
+
+const slowestCount = 3
+
+type Summary struct {
+	Files    int
+	Counts   map[string]int
+	BytesIn  int64
+	BytesOut int64
+	Saved    int64
+	Duration time.Duration
+	Slowest  []FileResult
+}
+
+func (s *Summary) Add(res FileResult) {
+	if s.Counts == nil {
+		s.Counts = make(map[string]int)
+	}
+	s.Files++
+	s.Counts[res.Status]++
+	s.BytesIn += res.BytesIn
+	s.BytesOut += res.BytesOut
+	if res.Status != "OK" && res.Status != "MAXED" {
+		return
+	}
+	s.Saved += res.BytesIn - res.BytesOut
+	s.Slowest = append(s.Slowest, res)
+	sort.SliceStable(s.Slowest, func(i, j int) bool { return s.Slowest[i].Duration > s.Slowest[j].Duration })
+	if len(s.Slowest) > slowestCount {
+		s.Slowest = s.Slowest[:slowestCount]
+	}
+}
+
+func (s Summary) Print() {
+	if s.Files == 0 {
+		return
+	}
+	fmt.Printf("Summary: %d files — OK %d, MAXED %d, SKIP %d, ERROR %d; saved %.1fKB.\n",
+		s.Files,
+		s.Counts["OK"],
+		s.Counts["MAXED"],
+		s.Counts["SKIP"],
+		s.Counts["ERROR"],
+		float64(s.Saved)/1024,
+	)
+	if len(s.Slowest) == 0 {
+		return
+	}
+	parts := make([]string, 0, len(s.Slowest))
+	for _, res := range s.Slowest {
+		parts = append(parts, fmt.Sprintf("%s %s", filepath.Base(res.Source), res.Duration.Truncate(time.Millisecond)))
+	}
+	fmt.Printf("Slowest: %s.\n", strings.Join(parts, ", "))
+}
+
+// Outcome maps the summary onto an exit status according to the --fail-on
+// policy: errors always fail the run, MAXED files only with "maxed".
+func (s Summary) Outcome(failOn string) error {
+	if n := s.Counts["ERROR"]; n > 0 {
+		return &ExitError{Code: ExitFailed, Msg: fmt.Sprintf("%d file(s) failed", n)}
+	}
+	if n := s.Counts["MAXED"]; n > 0 && failOn == "maxed" {
+		return &ExitError{Code: ExitMaxed, Msg: fmt.Sprintf("%d file(s) exceed the size target", n)}
+	}
+	return nil
+}
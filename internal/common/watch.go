@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func (s fileState) equal(o fileState) bool {
+	return s.size == o.size && s.modTime.Equal(o.modTime)
+}
+
+type pendingFile struct {
+	state fileState
+	since time.Time
+}
+
+func snapshot(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for _, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			states[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return states
+}
+
+func (r *batchRun) watch(ctx context.Context, known map[string]fileState, process ProcessFunc) error {
+	fmt.Printf("Watching %s (poll %s, settle %s). Press Ctrl-C to stop.\n", r.Input, r.PollInterval, r.Settle)
+
+	for path := range known {
+		if within(r.Output, path) {
+			delete(known, path)
+		}
+	}
+	pending := make(map[string]pendingFile)
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopped watching.")
+			return nil
+		case <-ticker.C:
+		}
+
+		files, err := r.Collect()
+		if err != nil {
+			fmt.Printf("[WATCH] scan %s: %v\n", r.Input, err)
+			continue
+		}
+
+		present := make(map[string]bool, len(files))
+		for _, path := range files {
+			// With --recursive the output directory may live under the input.
+			if within(r.Output, path) {
+				continue
+			}
+			present[path] = true
+
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			if prev, ok := known[path]; ok && prev.equal(state) {
+				delete(pending, path)
+				continue
+			}
+
+			p, ok := pending[path]
+			if !ok || !p.state.equal(state) {
+				if !ok {
+					if _, seen := known[path]; seen {
+						fmt.Printf("[WATCH] modified %s\n", path)
+					} else {
+						fmt.Printf("[WATCH] new %s\n", path)
+					}
+				}
+				pending[path] = pendingFile{state: state, since: time.Now()}
+				continue
+			}
+			if time.Since(p.since) < r.Settle {
+				continue
+			}
+
+			delete(pending, path)
+			known[path] = state
+			rel := r.relPath(path)
+			if err := r.processOne(ctx, path, rel, r.destFor(path, rel), process); err != nil {
+				return err
+			}
+		}
+
+		for path := range known {
+			if !present[path] {
+				delete(known, path)
+				fmt.Printf("[WATCH] removed %s\n", path)
+			}
+		}
+		for path := range pending {
+			if !present[path] {
+				delete(pending, path)
+			}
+		}
+	}
+}
@@ -2,13 +2,12 @@ package compress
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"math"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/yegorkir/jpgtools/internal/common"
 	"github.com/yegorkir/jpgtools/internal/imageutil"
@@ -16,24 +15,50 @@ import (
 )
 
 type options struct {
-	Input          string
-	Output         string
-	Recursive      bool
-	Overwrite      bool
-	DryRun         bool
+	common.BatchOptions
+	Bounds imageutil.ResizeBounds
+	// DebugCrop records the kept source rectangle of --fit cover in the report.
+	DebugCrop bool
+	Sharpen   imageutil.Sharpen
+	Denoise   string
+	QualitySearch
+	// Strategy is descend (the plain quality sweep) or grid.
+	Strategy string
+	Grid     Grid
+	Naming   *common.Naming
+}
+
+// QualitySearch describes the descending quality sweep used to fit an
+// encode under TargetBytes.
+type QualitySearch struct {
 	TargetBytes    int64
 	InitialQuality int
 	MinQuality     int
 	QualityStep    int
-	Bounds         imageutil.ResizeBounds
+	// Encode carries the cjpeg tuning flags; its Quality is set per attempt.
+	Encode mozjpeg.EncodeOptions
+}
+
+func (q QualitySearch) Validate() error {
+	if q.TargetBytes <= 0 {
+		return fmt.Errorf("target kilobytes must be positive")
+	}
+	if q.InitialQuality <= 0 || q.InitialQuality > 100 {
+		return fmt.Errorf("initial quality must be between 1 and 100")
+	}
+	if q.MinQuality <= 0 || q.MinQuality > q.InitialQuality {
+		return fmt.Errorf("min quality must be between 1 and initial quality")
+	}
+	if q.QualityStep <= 0 {
+		return fmt.Errorf("quality step must be positive")
+	}
+	return nil
 }
 
-func Run(args []string) error {
+func Run(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("compress", flag.ContinueOnError)
-	input := fs.String("input", ".", "Directory with source JPEGs.")
-	fs.StringVar(input, "i", ".", "Directory with source JPEGs.")
-	output := fs.String("output", "", "Destination directory (default: ./output_YYMMDDhhmm).")
-	fs.StringVar(output, "o", "", "Destination directory (default: ./output_YYMMDDhhmm).")
+	batchOpts := common.RegisterBatchFlags(fs)
+	common.RegisterInPlaceFlags(fs, batchOpts)
 	targetKB := fs.Int("target-kb", 300, "Maximum file size in kilobytes.")
 	maxKB := fs.Int("max-kb", 0, "Alias for --target-kb.")
 	initialQuality := fs.Int("initial-quality", 85, "Starting mozjpeg quality.")
@@ -43,29 +68,95 @@ func Run(args []string) error {
 	maxHeight := fs.Int("max-height", 1600, "Maximum height in pixels.")
 	minWidth := fs.Int("min-width", 1290, "Minimum width in pixels.")
 	minHeight := fs.Int("min-height", 800, "Minimum height in pixels.")
-	recursive := fs.Bool("recursive", false, "Recurse into subdirectories.")
-	overwrite := fs.Bool("overwrite", false, "Overwrite files in the output directory.")
-	dryRun := fs.Bool("dry-run", false, "Preview work without touching files.")
+	fit := fs.String("fit", "", "Produce exactly --width x --height: cover, contain, pad or fill (replaces the min/max bounds).")
+	width := fs.Int("width", 0, "Output width for --fit.")
+	height := fs.Int("height", 0, "Output height for --fit.")
+	gravity := fs.String("gravity", "center", "What --fit cover keeps (and where pad places the image): center, north, south, east, west, northeast, northwest, southeast, southwest, entropy or attention.")
+	padColor := fs.String("pad-color", "#ffffff", "Background for --fit pad as #RRGGBB.")
+	sharpen := fs.String("sharpen", "", "Unsharp mask after resizing: amount,radius,threshold (e.g. 0.5,1,2) or auto to scale with the downscale factor.")
+	denoise := fs.String("denoise", "off", "Filter sensor noise before encoding: off, light, strong, or auto to retry with light only when the target is missed.")
+	strategy := fs.String("strategy", "descend", "Quality search: descend, or grid to also try every --grid-sample and --grid-quant-table and keep the best result.")
+	gridSample := fs.String("grid-sample", "2x2,1x1", "Comma-separated chroma subsamplings for --strategy grid: 1x1, 2x1, 1x2, 2x2, or default for cjpeg's choice.")
+	gridQuantTable := fs.String("grid-quant-table", "default,2", "Comma-separated cjpeg quant tables (0-8 or default) for --strategy grid.")
+	targetSSIM := fs.Float64("target-ssim", 0, "With --strategy grid, keep the smallest result at or above this SSIM (e.g. 0.97) instead of the most faithful one under the size target.")
+	debugCrop := fs.Bool("debug-crop", false, "Record the source rectangle kept by --fit cover in the report.")
+	encode := mozjpeg.RegisterEncodeFlags(fs)
+	naming := common.RegisterNamingFlags(fs, "{dir}/{name}.{ext}")
+	config := common.RegisterConfigFlags(fs)
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if printed, err := config.Apply(fs, "compress"); err != nil || printed {
+		return err
+	}
+	if err := batchOpts.Validate(); err != nil {
+		return err
+	}
+	if err := naming.Validate(); err != nil {
+		return err
+	}
+	if batchOpts.InPlace && !naming.Mirrors() {
+		return fmt.Errorf("--in-place cannot be combined with --name-template or --flatten")
+	}
 
 	target := *targetKB
 	if *maxKB > 0 {
 		target = *maxKB
 	}
-	if target <= 0 {
-		return fmt.Errorf("target kilobytes must be positive")
+	search := QualitySearch{
+		TargetBytes:    int64(target) * 1024,
+		InitialQuality: *initialQuality,
+		MinQuality:     *minQuality,
+		QualityStep:    *qualityStep,
+		Encode:         *encode,
 	}
-	if *initialQuality <= 0 || *initialQuality > 100 {
-		return fmt.Errorf("initial quality must be between 1 and 100")
+	if err := search.Validate(); err != nil {
+		return err
 	}
-	if *minQuality <= 0 || *minQuality > *initialQuality {
-		return fmt.Errorf("min quality must be between 1 and initial quality")
+	if err := encode.Validate(); err != nil {
+		return err
 	}
-	if *qualityStep <= 0 {
-		return fmt.Errorf("quality step must be positive")
+	if batchOpts.InPlace && encode.Arithmetic {
+		return fmt.Errorf("--in-place cannot verify arithmetic-coded JPEGs; drop --arithmetic")
+	}
+	var grid Grid
+	switch *strategy {
+	case "descend":
+		if *targetSSIM != 0 {
+			return fmt.Errorf("--target-ssim requires --strategy grid")
+		}
+	case "grid":
+		if encode.Arithmetic {
+			return fmt.Errorf("--strategy grid cannot measure arithmetic-coded JPEGs; drop --arithmetic")
+		}
+		// An explicit --sample or --quant-table pins that axis of the grid.
+		set := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		samples, tables := *gridSample, *gridQuantTable
+		for _, pin := range []struct {
+			flag, grid string
+			value      *string
+			axis       *string
+		}{
+			{"sample", "grid-sample", &encode.Sample, &samples},
+			{"quant-table", "grid-quant-table", &encode.QuantTable, &tables},
+		} {
+			if !set[pin.flag] {
+				continue
+			}
+			if set[pin.grid] {
+				return fmt.Errorf("use either --%s or --%s with --strategy grid, not both", pin.flag, pin.grid)
+			}
+			*pin.axis = *pin.value
+		}
+		g, err := parseGrid(samples, tables, *targetSSIM)
+		if err != nil {
+			return err
+		}
+		grid = g
+	default:
+		return fmt.Errorf("unknown strategy %q (want descend or grid)", *strategy)
 	}
 
 	bounds := imageutil.ResizeBounds{
@@ -74,42 +165,53 @@ func Run(args []string) error {
 		MaxWidth:  *maxWidth,
 		MaxHeight: *maxHeight,
 	}
+	if *fit != "" {
+		pad, err := imageutil.ParseHexColor(*padColor)
+		if err != nil {
+			return err
+		}
+		bounds = imageutil.ResizeBounds{Fit: *fit, Width: *width, Height: *height, Gravity: *gravity, Pad: pad}
+	} else if *width != 0 || *height != 0 {
+		return fmt.Errorf("--width and --height require --fit")
+	}
 	if err := bounds.Validate(); err != nil {
 		return err
 	}
-
-	opt := options{
-		Input:          *input,
-		Recursive:      *recursive,
-		Overwrite:      *overwrite,
-		DryRun:         *dryRun,
-		TargetBytes:    int64(target) * 1024,
-		InitialQuality: *initialQuality,
-		MinQuality:     *minQuality,
-		QualityStep:    *qualityStep,
-		Bounds:         bounds,
-	}
-
-	out, err := common.ResolveOutputDir(*output)
+	sharpening, err := imageutil.ParseSharpen(*sharpen)
 	if err != nil {
 		return err
 	}
-	opt.Output = out
+	if err := imageutil.ValidateDenoise(*denoise); err != nil {
+		return err
+	}
 
-	if err := common.EnsureOutputDir(out, opt.Overwrite, opt.DryRun); err != nil {
+	defer batchOpts.Cleanup()
+	if err := batchOpts.PrepareOutput(); err != nil {
+		return err
+	}
+	if err := batchOpts.PrepareInput(fs.Args()); err != nil {
 		return err
 	}
+	opt := options{
+		BatchOptions:  *batchOpts,
+		Bounds:        bounds,
+		DebugCrop:     *debugCrop,
+		Sharpen:       sharpening,
+		Denoise:       *denoise,
+		QualitySearch: search,
+		Strategy:      *strategy,
+		Grid:          grid,
+		Naming:        naming,
+	}
 
-	files, err := common.CollectJPEGs(opt.Input, opt.Recursive)
+	files, err := opt.Collect()
 	if err != nil {
 		return err
 	}
-	if len(files) == 0 {
-		fmt.Printf("No JPEG files found in %s.\n", opt.Input)
+	if opt.NothingToDo(files) {
 		return nil
 	}
 
-	ctx := context.Background()
 	var tc *mozjpeg.Toolchain
 	if !opt.DryRun {
 		tc, err = mozjpeg.Ensure(ctx)
@@ -121,96 +223,233 @@ func Run(args []string) error {
 		fmt.Println("Running in dry-run mode. No files will be written.")
 	}
 
-	start := time.Now()
-	for _, src := range files {
-		rel, err := filepath.Rel(opt.Input, src)
-		if err != nil {
-			rel = filepath.Base(src)
-		}
-		dest := filepath.Join(opt.Output, rel)
-		if err := processFile(ctx, tc, src, dest, opt); err != nil {
-			fmt.Printf("[ERROR] %s: %v\n", src, err)
-		}
-	}
+	batch := opt.Batch(opt.hash())
+	batch.Naming = opt.Naming
+	return batch.Run(ctx, files, func(ctx context.Context, src, dest string) (common.FileResult, error) {
+		return processFile(ctx, tc, src, dest, opt)
+	})
+}
 
-	fmt.Printf("Done in %s.\n", time.Since(start).Truncate(time.Millisecond))
-	return nil
+func (o options) hash() string {
+	return common.HashOptions("compress", o.TargetBytes, o.InitialQuality, o.MinQuality, o.QualityStep, o.Encode, o.Strategy, o.Grid, o.Bounds, o.Sharpen, o.Denoise, o.Naming.Template, o.Naming.Flatten)
 }
 
-func processFile(ctx context.Context, tc *mozjpeg.Toolchain, src, dest string, opt options) error {
-	if _, err := os.Stat(dest); err == nil && !opt.Overwrite && !opt.DryRun {
-		fmt.Printf("[SKIP] %s exists (use --overwrite).\n", dest)
-		return nil
-	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
+func processFile(ctx context.Context, tc *mozjpeg.Toolchain, src, dest string, opt options) (common.FileResult, error) {
+	out, named, err := opt.Naming.Resolve(opt.Output, src, dest, common.NameVars{})
+	if err != nil {
+		return common.FileResult{}, err
+	}
+	if named {
+		if keep, err := opt.KeepExisting(out); err != nil {
+			return common.FileResult{}, err
+		} else if keep {
+			return skipped(out, common.FileResult{}), nil
+		}
 	}
 
 	imgInfo, err := imageutil.LoadAndResize(src, opt.Bounds)
 	if err != nil {
-		return err
+		return common.FileResult{}, err
 	}
 
 	note := imageutil.FormatDimensionNote(imgInfo.Original, imgInfo.Processed, opt.Bounds)
+	dims := common.FileResult{
+		Original:  imgInfo.Original,
+		Processed: imgInfo.Processed,
+		Warnings:  imageutil.DimensionWarnings(imgInfo.Original, imgInfo.Processed, opt.Bounds),
+	}
+	if c := imgInfo.Crop; opt.DebugCrop && !c.Empty() {
+		dims.Crop = []int{c.Min.X, c.Min.Y, c.Dx(), c.Dy()}
+	}
+
+	vars := common.NameVars{Width: imgInfo.Processed[0], Height: imgInfo.Processed[1]}
+	if !named {
+		if out, named, err = opt.Naming.Resolve(opt.Output, src, dest, vars); err != nil {
+			return dims, err
+		}
+		if named {
+			if keep, err := opt.KeepExisting(out); err != nil {
+				return dims, err
+			} else if keep {
+				return skipped(out, dims), nil
+			}
+		}
+	}
 
 	if opt.DryRun {
 		fmt.Printf("[DRY] %s -> %s (%s) target=%dKB quality=%d..%d step=%d\n",
 			filepath.Base(src),
-			dest,
+			opt.ShownDest(src, out),
 			note,
 			opt.TargetBytes/1024,
 			opt.InitialQuality,
 			opt.MinQuality,
 			opt.QualityStep,
 		)
-		return nil
+		dims.Dest = out
+		dims.Status = "DRY"
+		return dims, nil
 	}
 
-	ppmPath, err := imageutil.WritePPM(imgInfo.Image)
+	prepared := opt.prepare(imgInfo, opt.Denoise)
+	ppmPath, err := imageutil.WritePPM(prepared)
 	if err != nil {
-		return fmt.Errorf("write ppm: %w", err)
+		return dims, fmt.Errorf("write ppm: %w", err)
 	}
 	defer os.Remove(ppmPath)
 
-	finalQuality, finalSize, label, err := runQualityLoop(ctx, tc, ppmPath, dest, opt)
+	encodeTo := out
+	if !named {
+		encodeTo = opt.Naming.Provisional(opt.Output, src)
+		defer os.Remove(encodeTo)
+	}
+	res, err := opt.search(ctx, tc, prepared, ppmPath, encodeTo)
 	if err != nil {
-		return err
+		return dims, err
+	}
+	denoised := false
+	if res.Status == "MAXED" && opt.Denoise == "auto" {
+		if res, denoised, err = retryDenoised(ctx, tc, imgInfo, encodeTo, res, opt); err != nil {
+			return dims, err
+		}
+	}
+	res.Original = dims.Original
+	res.Processed = dims.Processed
+	res.Warnings = append(dims.Warnings, res.Warnings...)
+	res.Crop = dims.Crop
+	if denoised {
+		// A denoised retry also wins when it is merely smaller but still MAXED.
+		if res.Status == "OK" {
+			res.Warnings = append(res.Warnings, "denoised to reach the target")
+		} else {
+			res.Warnings = append(res.Warnings, "denoised (still above target)")
+		}
+		note += ", denoised"
+	}
+	if res.Sample != "" {
+		note += fmt.Sprintf(", sample=%s quant-table=%s ssim=%.4f", res.Sample, res.QuantTable, res.SSIM)
+	}
+
+	if !named {
+		vars.Quality = res.Quality
+		if out, _, err = opt.Naming.Resolve(opt.Output, src, dest, vars); err != nil {
+			return res, err
+		}
+		if keep, err := opt.KeepExisting(out); err != nil {
+			return dims, err
+		} else if keep {
+			return skipped(out, dims), nil
+		}
+		if err := opt.Naming.Place(encodeTo, out); err != nil {
+			return res, err
+		}
+	}
+	res.Dest = out
+
+	if opt.InPlace {
+		// Replacing a source with a bigger file defeats shrinking in place.
+		if info, err := os.Stat(src); err == nil && res.BytesOut >= info.Size() {
+			fmt.Printf("[SKIP] %s: result is not smaller (%.1fKB >= %.1fKB), keeping the original.\n",
+				src, float64(res.BytesOut)/1024, float64(info.Size())/1024)
+			res.Warnings = append(res.Warnings, "result not smaller than the source")
+			res.Status = "SKIP"
+			return res, nil
+		}
 	}
 
 	fmt.Printf("[%s] %s -> %s (%s) q=%d size=%.1fKB\n",
-		label,
+		res.Status,
 		filepath.Base(src),
-		dest,
+		opt.ShownDest(src, out),
 		note,
-		finalQuality,
-		float64(finalSize)/1024,
+		res.Quality,
+		float64(res.BytesOut)/1024,
 	)
-	return nil
+	return res, nil
+}
+
+// prepare applies the pre-encode filters; denoising comes first so the
+// sharpening does not amplify the noise it is about to remove.
+func (o options) prepare(info *imageutil.ImageInfo, denoise string) *image.NRGBA {
+	return o.Sharpen.Apply(imageutil.Denoise(info.Image, denoise), info.Scale())
 }
 
-func runQualityLoop(ctx context.Context, tc *mozjpeg.Toolchain, ppmPath, dest string, opt options) (int, int64, string, error) {
+// search encodes ppmPath, holding the pixels of img, to dest with the
+// selected strategy.
+func (o options) search(ctx context.Context, tc *mozjpeg.Toolchain, img *image.NRGBA, ppmPath, dest string) (common.FileResult, error) {
+	if o.Strategy == "grid" {
+		return SearchGrid(ctx, tc, img, ppmPath, dest, o.QualitySearch, o.Grid)
+	}
+	return SearchQuality(ctx, tc, ppmPath, dest, o.QualitySearch)
+}
+
+// retryDenoised reruns the quality search on a lightly denoised copy after
+// a plain search ended MAXED and keeps whichever result is better.
+func retryDenoised(ctx context.Context, tc *mozjpeg.Toolchain, info *imageutil.ImageInfo, dest string, first common.FileResult, opt options) (common.FileResult, bool, error) {
+	prepared := opt.prepare(info, "light")
+	ppmPath, err := imageutil.WritePPM(prepared)
+	if err != nil {
+		return first, false, fmt.Errorf("write ppm: %w", err)
+	}
+	defer os.Remove(ppmPath)
+
+	retry := dest + ".denoised"
+	defer os.Remove(retry)
+	res, err := opt.search(ctx, tc, prepared, ppmPath, retry)
+	if err != nil {
+		return first, false, err
+	}
+	res.Attempts += first.Attempts
+	if res.Status != "OK" && res.BytesOut >= first.BytesOut {
+		first.Attempts = res.Attempts
+		return first, false, nil
+	}
+	if err := os.Rename(retry, dest); err != nil {
+		return first, false, err
+	}
+	return res, true, nil
+}
+
+// skipped reports an output that already exists and is left untouched.
+func skipped(out string, res common.FileResult) common.FileResult {
+	fmt.Printf("[SKIP] %s exists (use --overwrite).\n", out)
+	res.Dest = out
+	res.Status = "SKIP"
+	return res
+}
+
+func SearchQuality(ctx context.Context, tc *mozjpeg.Toolchain, ppmPath, dest string, opt QualitySearch) (common.FileResult, error) {
 	bestPath := dest + ".best"
 	defer os.Remove(bestPath)
 	var bestSize int64 = math.MaxInt64
 	var bestQuality int
+	attempts := 0
 
 	for quality := opt.InitialQuality; quality >= opt.MinQuality; quality -= opt.QualityStep {
+		if err := ctx.Err(); err != nil {
+			return common.FileResult{}, err
+		}
+		attempts++
 		attempt := fmt.Sprintf("%s.q%d", dest, quality)
-		size, err := mozjpeg.EncodePPM(ctx, tc, ppmPath, attempt, mozjpeg.EncodeOptions{Quality: quality})
+		enc := opt.Encode
+		enc.Quality = quality
+		size, err := mozjpeg.EncodePPM(ctx, tc, ppmPath, attempt, enc)
 		if err != nil {
-			return 0, 0, "", err
+			os.Remove(attempt)
+			return common.FileResult{}, err
 		}
 		if size <= opt.TargetBytes {
 			os.Remove(dest)
 			if err := os.Rename(attempt, dest); err != nil {
-				return 0, 0, "", err
+				return common.FileResult{}, err
 			}
 			os.Remove(bestPath)
-			return quality, size, "OK", nil
+			return common.FileResult{Status: "OK", Quality: quality, Attempts: attempts, BytesOut: size}, nil
 		}
 		if size < bestSize {
 			os.Remove(bestPath)
 			if err := os.Rename(attempt, bestPath); err != nil {
-				return 0, 0, "", err
+				return common.FileResult{}, err
 			}
 			bestSize = size
 			bestQuality = quality
@@ -220,11 +459,11 @@ func runQualityLoop(ctx context.Context, tc *mozjpeg.Toolchain, ppmPath, dest st
 	}
 
 	if bestSize == math.MaxInt64 {
-		return 0, 0, "", fmt.Errorf("failed to encode %s", dest)
+		return common.FileResult{}, fmt.Errorf("failed to encode %s", dest)
 	}
 	os.Remove(dest)
 	if err := os.Rename(bestPath, dest); err != nil {
-		return 0, 0, "", err
+		return common.FileResult{}, err
 	}
-	return bestQuality, bestSize, "MAXED", nil
+	return common.FileResult{Status: "MAXED", Quality: bestQuality, Attempts: attempts, BytesOut: bestSize}, nil
 }
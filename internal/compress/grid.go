@@ -0,0 +1,186 @@
+package compress
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+
+	"github.com/yegorkir/jpgtools/internal/common"
+	"github.com/yegorkir/jpgtools/internal/imageutil"
+	"github.com/yegorkir/jpgtools/internal/mozjpeg"
+)
+
+// Grid lists the chroma subsamplings and quantisation tables the grid
+// strategy tries at every quality of the usual sweep; "" keeps cjpeg's
+// default. With TargetSSIM the smallest file at or above it wins,
+// otherwise the most faithful one (by SSIM) under the size target.
+type Grid struct {
+	Samples     []string
+	QuantTables []string
+	TargetSSIM  float64
+}
+
+func parseGrid(samples, tables string, targetSSIM float64) (Grid, error) {
+	g := Grid{TargetSSIM: targetSSIM}
+	if targetSSIM < 0 || targetSSIM > 1 {
+		return g, fmt.Errorf("target ssim must be between 0 and 1")
+	}
+	for _, s := range splitList(samples) {
+		if err := (mozjpeg.EncodeOptions{Sample: s}).Validate(); err != nil {
+			return g, err
+		}
+		g.Samples = append(g.Samples, s)
+	}
+	for _, t := range splitList(tables) {
+		if err := (mozjpeg.EncodeOptions{QuantTable: t}).Validate(); err != nil {
+			return g, err
+		}
+		g.QuantTables = append(g.QuantTables, t)
+	}
+	if len(g.Samples) == 0 || len(g.QuantTables) == 0 {
+		return g, fmt.Errorf("the grid needs at least one sampling and one quant table")
+	}
+	return g, nil
+}
+
+// splitList reads a comma-separated list where "default" stands for "".
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "":
+		case "default":
+			out = append(out, "")
+		default:
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+type gridCandidate struct {
+	path    string
+	quality int
+	sample  string
+	table   string
+	size    int64
+	ssim    float64
+}
+
+// SearchGrid encodes every (subsampling, quant table) pair at descending
+// qualities and measures the ones that fit against img, the exact pixels
+// in ppmPath. Each pair stops at the first quality under the size target,
+// or with TargetSSIM once quality falls below it. When nothing under the
+// size target reaches TargetSSIM the most faithful encode that fits is kept
+// with a warning; only without any candidate under the size target is the
+// smallest encode kept and reported as MAXED.
+func SearchGrid(ctx context.Context, tc *mozjpeg.Toolchain, img *image.NRGBA, ppmPath, dest string, opt QualitySearch, grid Grid) (common.FileResult, error) {
+	var files []string
+	defer func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}()
+	// fallback is the most faithful encode under the size target that
+	// misses TargetSSIM.
+	var best, fallback, smallest *gridCandidate
+	attempts := 0
+
+	for _, sample := range grid.Samples {
+		for _, table := range grid.QuantTables {
+			for quality := opt.InitialQuality; quality >= opt.MinQuality; quality -= opt.QualityStep {
+				if err := ctx.Err(); err != nil {
+					return common.FileResult{}, err
+				}
+				attempts++
+				enc := opt.Encode
+				enc.Quality, enc.Sample, enc.QuantTable = quality, sample, table
+				c := &gridCandidate{
+					path:    fmt.Sprintf("%s.%s-%s-q%d", dest, orDefault(sample), orDefault(table), quality),
+					quality: quality,
+					sample:  sample,
+					table:   table,
+				}
+				files = append(files, c.path)
+				size, err := mozjpeg.EncodePPM(ctx, tc, ppmPath, c.path, enc)
+				if err != nil {
+					return common.FileResult{}, err
+				}
+				c.size = size
+				if smallest == nil || size < smallest.size {
+					smallest = c
+				}
+				if size > opt.TargetBytes {
+					continue
+				}
+				if c.ssim, err = measureSSIM(img, c.path); err != nil {
+					return common.FileResult{}, err
+				}
+				if grid.TargetSSIM > 0 {
+					if c.ssim < grid.TargetSSIM {
+						if fallback == nil || c.ssim > fallback.ssim {
+							fallback = c
+						}
+						break
+					}
+					if best == nil || size < best.size {
+						best = c
+					}
+					continue
+				}
+				if best == nil || c.ssim > best.ssim || (c.ssim == best.ssim && size < best.size) {
+					best = c
+				}
+				break
+			}
+		}
+	}
+
+	if smallest == nil {
+		return common.FileResult{}, fmt.Errorf("failed to encode %s", dest)
+	}
+	winner, status := best, "OK"
+	var warnings []string
+	if winner == nil && fallback != nil {
+		winner = fallback
+		warnings = append(warnings, fmt.Sprintf("below target ssim %.4f", grid.TargetSSIM))
+	}
+	if winner == nil {
+		winner, status = smallest, "MAXED"
+		var err error
+		if winner.ssim, err = measureSSIM(img, winner.path); err != nil {
+			return common.FileResult{}, err
+		}
+	}
+	os.Remove(dest)
+	if err := os.Rename(winner.path, dest); err != nil {
+		return common.FileResult{}, err
+	}
+	return common.FileResult{
+		Status:     status,
+		Warnings:   warnings,
+		Quality:    winner.quality,
+		Attempts:   attempts,
+		BytesOut:   winner.size,
+		Sample:     orDefault(winner.sample),
+		QuantTable: orDefault(winner.table),
+		SSIM:       winner.ssim,
+	}, nil
+}
+
+func measureSSIM(img *image.NRGBA, path string) (float64, error) {
+	decoded, err := imageutil.Load(path)
+	if err != nil {
+		return 0, err
+	}
+	return imageutil.SSIM(img, decoded), nil
+}
+
+func orDefault(s string) string {
+	if s == "" {
+		return "default"
+	}
+	return s
+}
@@ -0,0 +1,118 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// blendModes follow the W3C compositing spec; channels are 0..1 and b is the
+// backdrop (the photo), s the overlay color.
+var blendModes = map[string]func(b, s [3]float64) [3]float64{
+	"normal":     func(_, s [3]float64) [3]float64 { return s },
+	"multiply":   separable(func(b, s float64) float64 { return b * s }),
+	"screen":     separable(screen),
+	"overlay":    separable(func(b, s float64) float64 { return hardLight(s, b) }),
+	"soft-light": separable(softLight),
+	"color": func(b, s [3]float64) [3]float64 {
+		return setLum(s, lum(b))
+	},
+}
+
+func ValidateBlend(name string) error {
+	if _, ok := blendModes[name]; !ok {
+		return fmt.Errorf("unknown blend mode %q (want normal, multiply, screen, overlay, soft-light or color)", name)
+	}
+	return nil
+}
+
+// BlendPixel returns the 0..255 result of compositing c at opacity alpha
+// over base with the named mode.
+func BlendPixel(mode string, base, c [3]float64, alpha float64) [3]float64 {
+	var b, s [3]float64
+	for k := range b {
+		b[k], s[k] = base[k]/255, c[k]/255
+	}
+	mixed := blendModes[mode](b, s)
+	var out [3]float64
+	for k := range out {
+		v := (b[k]*(1-alpha) + mixed[k]*alpha) * 255
+		out[k] = math.Max(0, math.Min(255, v))
+	}
+	return out
+}
+
+// CompositeBlend composites layer over img using the named blend mode.
+// "normal" is plain Composite so existing results stay byte-identical.
+func CompositeBlend(img *image.NRGBA, layer Layer, mode string) {
+	if mode == "" || mode == "normal" {
+		Composite(img, layer)
+		return
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[(y-b.Min.Y)*img.Stride:]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c, alpha := layer(x, y)
+			if alpha <= 0 {
+				continue
+			}
+			px := row[(x-b.Min.X)*4:]
+			out := BlendPixel(mode, [3]float64{float64(px[0]), float64(px[1]), float64(px[2])}, c, math.Min(alpha, 1))
+			px[0] = uint8(math.Round(out[0]))
+			px[1] = uint8(math.Round(out[1]))
+			px[2] = uint8(math.Round(out[2]))
+		}
+	}
+}
+
+func separable(f func(b, s float64) float64) func(b, s [3]float64) [3]float64 {
+	return func(b, s [3]float64) [3]float64 {
+		return [3]float64{f(b[0], s[0]), f(b[1], s[1]), f(b[2], s[2])}
+	}
+}
+
+func screen(b, s float64) float64 {
+	return b + s - b*s
+}
+
+func hardLight(b, s float64) float64 {
+	if s <= 0.5 {
+		return b * 2 * s
+	}
+	return screen(b, 2*s-1)
+}
+
+func softLight(b, s float64) float64 {
+	if s <= 0.5 {
+		return b - (1-2*s)*b*(1-b)
+	}
+	d := math.Sqrt(b)
+	if b <= 0.25 {
+		d = ((16*b-12)*b + 4) * b
+	}
+	return b + (2*s-1)*(d-b)
+}
+
+func lum(c [3]float64) float64 {
+	return 0.3*c[0] + 0.59*c[1] + 0.11*c[2]
+}
+
+// setLum keeps the hue and saturation of c with luminosity l, clipping
+// back into gamut as the spec describes.
+func setLum(c [3]float64, l float64) [3]float64 {
+	d := l - lum(c)
+	c = [3]float64{c[0] + d, c[1] + d, c[2] + d}
+	l = lum(c)
+	lo := math.Min(c[0], math.Min(c[1], c[2]))
+	hi := math.Max(c[0], math.Max(c[1], c[2]))
+	for k := range c {
+		if lo < 0 {
+			c[k] = l + (c[k]-l)*l/(l-lo)
+		}
+		if hi > 1 {
+			c[k] = l + (c[k]-l)*(1-l)/(hi-l)
+		}
+	}
+	return c
+}
@@ -0,0 +1,64 @@
+package imageutil
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBlendPixel(t *testing.T) {
+	tests := []struct {
+		mode  string
+		base  [3]float64
+		c     [3]float64
+		alpha float64
+		want  [3]float64
+	}{
+		{"normal", [3]float64{200, 100, 50}, [3]float64{10, 20, 30}, 1, [3]float64{10, 20, 30}},
+		{"normal", [3]float64{200, 100, 50}, [3]float64{10, 20, 30}, 0.5, [3]float64{105, 60, 40}},
+		{"multiply", [3]float64{255, 128, 0}, [3]float64{128, 128, 128}, 1, [3]float64{128, 64.251, 0}},
+		{"multiply", [3]float64{200, 100, 50}, [3]float64{255, 255, 255}, 1, [3]float64{200, 100, 50}},
+		{"screen", [3]float64{255, 128, 0}, [3]float64{128, 128, 128}, 1, [3]float64{255, 191.749, 128}},
+		{"screen", [3]float64{200, 100, 50}, [3]float64{0, 0, 0}, 1, [3]float64{200, 100, 50}},
+		{"overlay", [3]float64{64, 192, 128}, [3]float64{128, 128, 128}, 1, [3]float64{64.251, 192.2471, 128.498}},
+		{"overlay", [3]float64{64, 192, 0}, [3]float64{255, 0, 200}, 1, [3]float64{128, 129, 0}},
+		{"soft-light", [3]float64{32, 128, 200}, [3]float64{64, 64, 64}, 1, [3]float64{18.0627, 96.2505, 178.516}},
+		{"soft-light", [3]float64{32, 128, 200}, [3]float64{200, 200, 200}, 1, [3]float64{63.7719, 157.947, 214.6887}},
+		{"color", [3]float64{200, 100, 50}, [3]float64{60, 90, 120}, 0.5, [3]float64{150.1, 115.1, 105.1}},
+		// setLum clips out-of-gamut results back towards the luminosity.
+		{"color", [3]float64{255, 255, 255}, [3]float64{255, 0, 0}, 1, [3]float64{255, 255, 255}},
+		{"color", [3]float64{0, 0, 0}, [3]float64{255, 0, 0}, 1, [3]float64{0, 0, 0}},
+		{"color", [3]float64{128, 128, 128}, [3]float64{255, 0, 0}, 1, [3]float64{255, 73.5714, 73.5714}},
+		{"color", [3]float64{128, 128, 128}, [3]float64{0, 255, 255}, 1, [3]float64{0, 182.8571, 182.8571}},
+	}
+	for _, tt := range tests {
+		got := BlendPixel(tt.mode, tt.base, tt.c, tt.alpha)
+		for k := range got {
+			if math.Abs(got[k]-tt.want[k]) > 0.001 {
+				t.Errorf("%s %v over %v at %g = %v, want %v", tt.mode, tt.c, tt.base, tt.alpha, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSetLumClips(t *testing.T) {
+	tests := []struct {
+		c [3]float64
+		l float64
+	}{
+		{[3]float64{1, 0, 0}, 0.9},
+		{[3]float64{0, 1, 1}, 0.1},
+		{[3]float64{0.2, 0.9, 0.4}, 0.5},
+	}
+	for _, tt := range tests {
+		got := setLum(tt.c, tt.l)
+		for _, v := range got {
+			if v < -1e-9 || v > 1+1e-9 {
+				t.Errorf("setLum(%v, %g) = %v, out of gamut", tt.c, tt.l, got)
+			}
+		}
+		if math.Abs(lum(got)-tt.l) > 1e-9 {
+			t.Errorf("setLum(%v, %g) has luminosity %g", tt.c, tt.l, lum(got))
+		}
+	}
+}
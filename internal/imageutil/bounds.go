@@ -2,6 +2,7 @@ package imageutil
 
 import (
 	"fmt"
+	"image/color"
 	"math"
 	"strings"
 )
@@ -11,12 +12,28 @@ type ResizeBounds struct {
 	MinHeight int
 	MaxWidth  int
 	MaxHeight int
+
+	// Fit, when set, targets exactly Width x Height and replaces the min/max
+	// bounds: cover scales to fill and crops at Gravity, contain scales to
+	// fit inside, pad does the same and fills the rest with Pad, and fill
+	// stretches.
+	Fit     string
+	Width   int
+	Height  int
+	Gravity string
+	Pad     color.NRGBA
 }
 
 func (b ResizeBounds) Validate() error {
 	if b.MinWidth < 0 || b.MinHeight < 0 || b.MaxWidth < 0 || b.MaxHeight < 0 {
 		return fmt.Errorf("bounds must be non-negative")
 	}
+	if b.Fit != "" {
+		return validateFit(b)
+	}
+	if b.Width != 0 || b.Height != 0 {
+		return fmt.Errorf("exact width and height require a fit mode")
+	}
 	return nil
 }
 
@@ -66,6 +83,19 @@ func FormatDimensionNote(original, processed [2]int, bounds ResizeBounds) string
 	}
 
 	note = fmt.Sprintf("%s->%dx%d", note, processed[0], processed[1])
+	if bounds.Fit != "" {
+		return fmt.Sprintf("%s (%s)", note, bounds.fitNote())
+	}
+	if warnings := DimensionWarnings(original, processed, bounds); len(warnings) > 0 {
+		note = fmt.Sprintf("%s (%s)", note, strings.Join(warnings, ", "))
+	}
+	return note
+}
+
+func DimensionWarnings(original, processed [2]int, bounds ResizeBounds) []string {
+	if original == processed || bounds.Fit != "" {
+		return nil
+	}
 	warnings := make([]string, 0, 2)
 	if bounds.MinWidth > 0 && processed[0] < bounds.MinWidth {
 		warnings = append(warnings, "below min width")
@@ -79,8 +109,5 @@ func FormatDimensionNote(original, processed [2]int, bounds ResizeBounds) string
 	if bounds.MaxHeight > 0 && processed[1] > bounds.MaxHeight {
 		warnings = append(warnings, "above max height")
 	}
-	if len(warnings) > 0 {
-		note = fmt.Sprintf("%s (%s)", note, strings.Join(warnings, ", "))
-	}
-	return note
+	return warnings
 }
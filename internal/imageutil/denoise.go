@@ -0,0 +1,92 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// denoiseLevels set the median window radius for luma and chroma and how
+// far (in levels) a luma sample may be from its median and still be
+// treated as noise; larger deviations are detail and are kept.
+var denoiseLevels = map[string]struct {
+	lumaRadius, chromaRadius int
+	lumaLimit                int
+}{
+	"light":  {1, 2, 12},
+	"strong": {2, 3, 24},
+}
+
+// ValidateDenoise accepts off, light, strong and auto; auto is resolved by
+// the caller, which retries with light when the size target is missed.
+func ValidateDenoise(level string) error {
+	if _, ok := denoiseLevels[level]; ok || level == "" || level == "off" || level == "auto" {
+		return nil
+	}
+	return fmt.Errorf("unknown denoise level %q (want off, light, strong or auto)", level)
+}
+
+// Denoise returns a copy of img with luma and chroma filtered separately:
+// chroma noise gets a plain median, luma an edge-preserving one that only
+// replaces samples close to their neighbourhood median.
+func Denoise(img *image.NRGBA, level string) *image.NRGBA {
+	cfg, ok := denoiseLevels[level]
+	if !ok {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	ys, cbs, crs := make([]uint8, w*h), make([]uint8, w*h), make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			px := row[x*4:]
+			i := y*w + x
+			ys[i], cbs[i], crs[i] = color.RGBToYCbCr(px[0], px[1], px[2])
+		}
+	}
+	ys = medianFilter(ys, w, h, cfg.lumaRadius, cfg.lumaLimit)
+	cbs = medianFilter(cbs, w, h, cfg.chromaRadius, 255)
+	crs = medianFilter(crs, w, h, cfg.chromaRadius, 255)
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			r, g, b := color.YCbCrToRGB(ys[i], cbs[i], crs[i])
+			dst[x*4], dst[x*4+1], dst[x*4+2], dst[x*4+3] = r, g, b, src[x*4+3]
+		}
+	}
+	return out
+}
+
+// medianFilter replaces each sample with the median of its (2r+1)² window
+// when the two differ by at most limit.
+func medianFilter(src []uint8, w, h, r, limit int) []uint8 {
+	out := make([]uint8, len(src))
+	window := make([]uint8, 0, (2*r+1)*(2*r+1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			window = window[:0]
+			for dy := -r; dy <= r; dy++ {
+				row := clampInt(y+dy, 0, h-1) * w
+				for dx := -r; dx <= r; dx++ {
+					window = append(window, src[row+clampInt(x+dx, 0, w-1)])
+				}
+			}
+			// Windows hold at most 49 samples, where insertion sort wins.
+			for i := 1; i < len(window); i++ {
+				for j := i; j > 0 && window[j] < window[j-1]; j-- {
+					window[j], window[j-1] = window[j-1], window[j]
+				}
+			}
+			v, m := src[y*w+x], window[len(window)/2]
+			if d := int(v) - int(m); d <= limit && d >= -limit {
+				v = m
+			}
+			out[y*w+x] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package imageutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+)
+
+// ReadDateTaken returns EXIF DateTimeOriginal, falling back to the IFD0
+// DateTime tag. The second result is false when the JPEG carries neither.
+func ReadDateTaken(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	tiff, ok := findExif(bufio.NewReader(f))
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseExifDate(tiff)
+}
+
+func findExif(r *bufio.Reader) ([]byte, bool) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, false
+	}
+	for {
+		var marker [2]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return nil, false
+		}
+		// Start of scan or end of image: metadata segments are over.
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, false
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint16(size[:])) - 2
+		if n < 0 {
+			return nil, false
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, false
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:], true
+		}
+	}
+}
+
+func parseExifDate(tiff []byte) (time.Time, bool) {
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if off, ok := ifd0[tagExifIFD]; ok {
+		exif := readIFD(tiff, order, order.Uint32(off))
+		if v, ok := exif[tagDateTimeOriginal]; ok {
+			if t, ok := parseExifTime(tiff, order, v); ok {
+				return t, true
+			}
+		}
+	}
+	if v, ok := ifd0[tagDateTime]; ok {
+		return parseExifTime(tiff, order, v)
+	}
+	return time.Time{}, false
+}
+
+// readIFD returns the raw 4-byte value/offset field of each entry.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := make(map[uint16][]byte)
+	if int(offset)+2 > len(tiff) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	pos := int(offset) + 2
+	for i := 0; i < count && pos+12 <= len(tiff); i++ {
+		entries[order.Uint16(tiff[pos:])] = tiff[pos+8 : pos+12]
+		pos += 12
+	}
+	return entries
+}
+
+func parseExifTime(tiff []byte, order binary.ByteOrder, field []byte) (time.Time, bool) {
+	// Date strings are 20 bytes of ASCII, always stored out of line.
+	off := int(order.Uint32(field))
+	if off < 0 || off+19 > len(tiff) {
+		return time.Time{}, false
+	}
+	raw := strings.TrimRight(string(tiff[off:off+19]), "\x00 ")
+	t, err := time.Parse("2006:01:02 15:04:05", raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
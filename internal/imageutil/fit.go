@@ -0,0 +1,200 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// gravities place a crop window (cover) or the scaled image (pad) as
+// fractions of the free space; entropy and attention pick the crop from the
+// content instead and fall back to center for pad.
+var gravities = map[string][2]float64{
+	"center":    {0.5, 0.5},
+	"north":     {0.5, 0},
+	"south":     {0.5, 1},
+	"east":      {1, 0.5},
+	"west":      {0, 0.5},
+	"northeast": {1, 0},
+	"northwest": {0, 0},
+	"southeast": {1, 1},
+	"southwest": {0, 1},
+}
+
+func validateFit(b ResizeBounds) error {
+	switch b.Fit {
+	case "cover", "contain", "pad", "fill":
+	default:
+		return fmt.Errorf("unknown fit %q (want cover, contain, pad or fill)", b.Fit)
+	}
+	if b.Width <= 0 || b.Height <= 0 {
+		return fmt.Errorf("fit %s needs a positive width and height", b.Fit)
+	}
+	if _, ok := gravities[b.Gravity]; !ok && b.Gravity != "" && b.Gravity != "entropy" && b.Gravity != "attention" {
+		return fmt.Errorf("unknown gravity %q (want center, north, south, east, west, northeast, northwest, southeast, southwest, entropy or attention)", b.Gravity)
+	}
+	return nil
+}
+
+func (b ResizeBounds) fitNote() string {
+	_, placed := gravities[b.Gravity]
+	if b.Gravity == "" || b.Gravity == "center" || b.Fit == "contain" || b.Fit == "fill" || (b.Fit == "pad" && !placed) {
+		return b.Fit
+	}
+	return b.Fit + " " + b.Gravity
+}
+
+func fitImage(img *image.NRGBA, b ResizeBounds) *ImageInfo {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	info := &ImageInfo{Original: [2]int{w, h}}
+	sx, sy := float64(b.Width)/float64(w), float64(b.Height)/float64(h)
+
+	switch b.Fit {
+	case "fill":
+		info.Image = resizeBilinear(img, b.Width, b.Height)
+		info.Factor = sx
+	case "cover":
+		s := math.Max(sx, sy)
+		cw := clampInt(int(math.Round(float64(b.Width)/s)), 1, w)
+		ch := clampInt(int(math.Round(float64(b.Height)/s)), 1, h)
+		crop := cropWindow(img, cw, ch, b.Gravity)
+		info.Crop = crop.Sub(img.Rect.Min)
+		info.Factor = s
+		info.Image = resizeBilinear(toNRGBA(img.SubImage(crop)), b.Width, b.Height)
+	default:
+		s := math.Min(sx, sy)
+		scaled := resizeBilinear(img, max(1, int(math.Round(float64(w)*s))), max(1, int(math.Round(float64(h)*s))))
+		info.Image = scaled
+		info.Factor = s
+		if b.Fit == "pad" {
+			canvas := image.NewNRGBA(image.Rect(0, 0, b.Width, b.Height))
+			draw.Draw(canvas, canvas.Rect, image.NewUniform(b.Pad), image.Point{}, draw.Src)
+			g, ok := gravities[b.Gravity]
+			if !ok {
+				g = gravities["center"]
+			}
+			at := image.Pt(
+				int(math.Round(g[0]*float64(b.Width-scaled.Rect.Dx()))),
+				int(math.Round(g[1]*float64(b.Height-scaled.Rect.Dy()))),
+			)
+			draw.Draw(canvas, scaled.Rect.Add(at), scaled, scaled.Rect.Min, draw.Src)
+			info.Image = canvas
+		}
+	}
+	info.Processed = [2]int{info.Image.Rect.Dx(), info.Image.Rect.Dy()}
+	return info
+}
+
+// cropWindow returns the cw x ch rectangle of img to keep for gravity.
+func cropWindow(img *image.NRGBA, cw, ch int, gravity string) image.Rectangle {
+	b := img.Rect
+	if gravity == "entropy" || gravity == "attention" {
+		return contentWindow(img, cw, ch, gravity).Add(b.Min)
+	}
+	g, ok := gravities[gravity]
+	if !ok {
+		g = gravities["center"]
+	}
+	x := int(math.Round(g[0] * float64(b.Dx()-cw)))
+	y := int(math.Round(g[1] * float64(b.Dy()-ch)))
+	return image.Rect(x, y, x+cw, y+ch).Add(b.Min)
+}
+
+// contentWindow searches a downscaled copy for the window with the most
+// detail: the highest luma entropy, or for attention the highest total
+// saliency. The result is in img's coordinates relative to its origin.
+func contentWindow(img *image.NRGBA, cw, ch int, mode string) image.Rectangle {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	f := math.Min(1, 256/float64(max(w, h)))
+	sw, sh := max(1, int(math.Round(float64(w)*f))), max(1, int(math.Round(float64(h)*f)))
+	small := resizeBilinear(img, sw, sh)
+	luma := lumaOf(small)
+	ww := clampInt(int(math.Round(float64(cw)*f)), 1, sw)
+	wh := clampInt(int(math.Round(float64(ch)*f)), 1, sh)
+
+	var score func(x, y int) float64
+	if mode == "entropy" {
+		score = func(x, y int) float64 { return windowEntropy(luma, sw, x, y, ww, wh) }
+	} else {
+		sat := newSummedArea(saliencyMap(small), sw, sh)
+		score = func(x, y int) float64 { return sat.sum(x, y, ww, wh) }
+	}
+
+	bestX, bestY, best := 0, 0, math.Inf(-1)
+	for y := 0; y <= sh-wh; y++ {
+		for x := 0; x <= sw-ww; x++ {
+			if s := score(x, y); s > best {
+				bestX, bestY, best = x, y, s
+			}
+		}
+	}
+	x := clampInt(int(math.Round(float64(bestX)/f)), 0, w-cw)
+	y := clampInt(int(math.Round(float64(bestY)/f)), 0, h-ch)
+	return image.Rect(x, y, x+cw, y+ch)
+}
+
+func lumaOf(img *image.NRGBA) []float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			px := row[x*4:]
+			luma[y*w+x] = 0.299*float64(px[0]) + 0.587*float64(px[1]) + 0.114*float64(px[2])
+		}
+	}
+	return luma
+}
+
+func windowEntropy(luma []float64, stride, x0, y0, w, h int) float64 {
+	var hist [32]int
+	for y := y0; y < y0+h; y++ {
+		for _, v := range luma[y*stride+x0 : y*stride+x0+w] {
+			hist[min(int(v)/8, 31)]++
+		}
+	}
+	n := float64(w * h)
+	e := 0.0
+	for _, c := range hist {
+		if c > 0 {
+			p := float64(c) / n
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// edgeMap is the absolute luma gradient at each pixel.
+func edgeMap(luma []float64, w, h int) []float64 {
+	edges := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			dx := luma[y*w+min(x+1, w-1)] - luma[y*w+max(x-1, 0)]
+			dy := luma[min(y+1, h-1)*w+x] - luma[max(y-1, 0)*w+x]
+			edges[i] = math.Abs(dx) + math.Abs(dy)
+		}
+	}
+	return edges
+}
+
+// summedArea answers rectangle sums of a score map in constant time.
+type summedArea struct {
+	w    int
+	sums []float64
+}
+
+func newSummedArea(values []float64, w, h int) summedArea {
+	s := summedArea{w: w + 1, sums: make([]float64, (w+1)*(h+1))}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			s.sums[(y+1)*s.w+x+1] = values[y*w+x] + s.sums[y*s.w+x+1] + s.sums[(y+1)*s.w+x] - s.sums[y*s.w+x]
+		}
+	}
+	return s
+}
+
+func (s summedArea) sum(x, y, w, h int) float64 {
+	return s.sums[(y+h)*s.w+x+w] - s.sums[y*s.w+x+w] - s.sums[(y+h)*s.w+x] + s.sums[y*s.w+x]
+}
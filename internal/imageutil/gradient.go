@@ -0,0 +1,136 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var easings = map[string]func(float64) float64{
+	"linear":      func(t float64) float64 { return t },
+	"ease-in":     func(t float64) float64 { return t * t },
+	"ease-out":    func(t float64) float64 { return 1 - (1-t)*(1-t) },
+	"ease-in-out": func(t float64) float64 { return t * t * (3 - 2*t) },
+}
+
+func ValidateEasing(name string) error {
+	if _, ok := easings[name]; !ok {
+		return fmt.Errorf("unknown easing %q (want linear, ease-in, ease-out or ease-in-out)", name)
+	}
+	return nil
+}
+
+type GradientStop struct {
+	Pos   float64
+	Color color.NRGBA
+	Alpha float64
+}
+
+// ParseGradientStop reads "pos:#RRGGBB@alpha"; pos is a fraction or a
+// percentage along the gradient and the color or alpha may be omitted.
+func ParseGradientStop(s string, defColor color.NRGBA, defAlpha float64) (GradientStop, error) {
+	stop := GradientStop{Color: defColor, Alpha: defAlpha}
+	pos, rest, _ := strings.Cut(strings.TrimSpace(s), ":")
+	p, err := parseFraction(pos)
+	if err != nil {
+		return stop, fmt.Errorf("gradient stop %q: %w", s, err)
+	}
+	stop.Pos = p
+	hex, alpha, hasAlpha := strings.Cut(rest, "@")
+	if hex != "" {
+		if stop.Color, err = ParseHexColor(hex); err != nil {
+			return stop, fmt.Errorf("gradient stop %q: %w", s, err)
+		}
+	}
+	if hasAlpha {
+		if stop.Alpha, err = strconv.ParseFloat(alpha, 64); err != nil || stop.Alpha < 0 || stop.Alpha > 1 {
+			return stop, fmt.Errorf("gradient stop %q: alpha must be between 0 and 1", s)
+		}
+	}
+	return stop, nil
+}
+
+// parseFraction reads 0..1 or 0%..100%.
+func parseFraction(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), 100
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	v /= scale
+	if v < 0 || v > 1 {
+		return 0, fmt.Errorf("%s is outside 0..1", s)
+	}
+	return v, nil
+}
+
+// Gradient is a linear or radial color/opacity ramp. Angles follow CSS:
+// 0 points up, 90 right and 180 down, so 180 darkens towards the bottom.
+// Radial gradients are ellipses through the farthest corner, centred at
+// Center given as fractions of the width and height.
+type Gradient struct {
+	Radial bool
+	Angle  float64
+	Center [2]float64
+	Stops  []GradientStop
+	Easing string
+}
+
+func (g Gradient) Layer(bounds image.Rectangle) Layer {
+	stops := append([]GradientStop(nil), g.Stops...)
+	sort.SliceStable(stops, func(i, j int) bool { return stops[i].Pos < stops[j].Pos })
+	ease := easings[g.Easing]
+	if ease == nil {
+		ease = easings["linear"]
+	}
+
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	var position func(x, y float64) float64
+	if g.Radial {
+		cx, cy := g.Center[0]*w, g.Center[1]*h
+		rx := math.Max(cx, w-cx) * math.Sqrt2
+		ry := math.Max(cy, h-cy) * math.Sqrt2
+		position = func(x, y float64) float64 {
+			return math.Hypot((x-cx)/rx, (y-cy)/ry)
+		}
+	} else {
+		rad := g.Angle * math.Pi / 180
+		dx, dy := math.Sin(rad), -math.Cos(rad)
+		length := math.Abs(w*dx) + math.Abs(h*dy)
+		position = func(x, y float64) float64 {
+			return ((x-w/2)*dx+(y-h/2)*dy)/length + 0.5
+		}
+	}
+
+	return func(x, y int) ([3]float64, float64) {
+		t := position(float64(x-bounds.Min.X)+0.5, float64(y-bounds.Min.Y)+0.5)
+		return sampleStops(stops, t, ease)
+	}
+}
+
+func sampleStops(stops []GradientStop, t float64, ease func(float64) float64) ([3]float64, float64) {
+	first, last := stops[0], stops[len(stops)-1]
+	if t <= first.Pos {
+		return rgbOf(first.Color), first.Alpha
+	}
+	if t >= last.Pos {
+		return rgbOf(last.Color), last.Alpha
+	}
+	i := sort.Search(len(stops), func(i int) bool { return stops[i].Pos > t }) - 1
+	a, b := stops[i], stops[i+1]
+	u := ease((t - a.Pos) / (b.Pos - a.Pos))
+	ca, cb := rgbOf(a.Color), rgbOf(b.Color)
+	var c [3]float64
+	for k := range c {
+		c[k] = ca[k] + (cb[k]-ca[k])*u
+	}
+	return c, a.Alpha + (b.Alpha-a.Alpha)*u
+}
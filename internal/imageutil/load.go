@@ -7,6 +7,7 @@ import (
 	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"io"
 	"math"
 	"os"
 )
@@ -15,9 +16,22 @@ type ImageInfo struct {
 	Image     *image.NRGBA
 	Original  [2]int
 	Processed [2]int
+	// Crop is the part of the original kept by --fit cover, empty otherwise.
+	Crop image.Rectangle
+	// Factor is the linear scale applied to the original's pixels, which
+	// for --fit pad differs from the canvas size.
+	Factor float64
 }
 
 func LoadAndResize(path string, bounds ResizeBounds) (*ImageInfo, error) {
+	img, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return Resize(img, bounds), nil
+}
+
+func Load(path string) (*image.NRGBA, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -28,13 +42,22 @@ func LoadAndResize(path string, bounds ResizeBounds) (*ImageInfo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("decode %s: %w", path, err)
 	}
+	return toNRGBA(cfg), nil
+}
 
-	img := toNRGBA(cfg)
+// Resize never modifies img; when no scaling is needed the returned
+// ImageInfo shares it.
+func Resize(img *image.NRGBA, bounds ResizeBounds) *ImageInfo {
+	if bounds.Fit != "" {
+		return fitImage(img, bounds)
+	}
 	original := [2]int{img.Bounds().Dx(), img.Bounds().Dy()}
 	processed := original
+	factor := 1.0
 
 	scale := DetermineScaleFactor(original[0], original[1], bounds)
 	if math.Abs(scale-1) > 1e-3 {
+		factor = scale
 		w := max(1, int(math.Round(float64(original[0])*scale)))
 		h := max(1, int(math.Round(float64(original[1])*scale)))
 		img = resizeBilinear(img, w, h)
@@ -45,7 +68,8 @@ func LoadAndResize(path string, bounds ResizeBounds) (*ImageInfo, error) {
 		Image:     img,
 		Original:  original,
 		Processed: processed,
-	}, nil
+		Factor:    factor,
+	}
 }
 
 func WritePPM(img *image.NRGBA) (string, error) {
@@ -53,41 +77,43 @@ func WritePPM(img *image.NRGBA) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	bw := bufio.NewWriter(tmp)
-	w := img.Bounds().Dx()
-	h := img.Bounds().Dy()
-	if _, err := fmt.Fprintf(bw, "P6\n%d %d\n255\n", w, h); err != nil {
+	if err := writePPM(tmp, img); err != nil {
 		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
 		return "", err
 	}
+	return tmp.Name(), nil
+}
+
+func writePPM(w io.Writer, img *image.NRGBA) error {
+	bw := bufio.NewWriter(w)
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	if _, err := fmt.Fprintf(bw, "P6\n%d %d\n255\n", width, height); err != nil {
+		return err
+	}
 
-	row := make([]byte, w*3)
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
+	row := make([]byte, width*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
 			c := color.NRGBAModel.Convert(img.NRGBAAt(x, y)).(color.NRGBA)
 			row[x*3+0] = c.R
 			row[x*3+1] = c.G
 			row[x*3+2] = c.B
 		}
 		if _, err := bw.Write(row); err != nil {
-			tmp.Close()
-			return "", err
+			return err
 		}
 	}
-
-	if err := bw.Flush(); err != nil {
-		tmp.Close()
-		return "", err
-	}
-	if err := tmp.Close(); err != nil {
-		return "", err
-	}
-	return tmp.Name(), nil
+	return bw.Flush()
 }
 
 func toNRGBA(src image.Image) *image.NRGBA {
-	if nrgba, ok := src.(*image.NRGBA); ok && nrgba.Stride == nrgba.Rect.Dx()*4 {
+	if nrgba, ok := src.(*image.NRGBA); ok && nrgba.Stride == nrgba.Rect.Dx()*4 && nrgba.Rect.Min == (image.Point{}) {
 		return nrgba
 	}
 	b := src.Bounds()
@@ -167,3 +193,37 @@ func max(a, b int) int {
 	}
 	return b
 }
+
+// VerifyJPEG fully decodes path and, when dims is non-zero, checks that the
+// image has those dimensions.
+func VerifyJPEG(path string, dims [2]int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		return err
+	}
+	got := [2]int{img.Bounds().Dx(), img.Bounds().Dy()}
+	if dims != [2]int{} && got != dims {
+		return fmt.Errorf("%s is %dx%d, expected %dx%d", path, got[0], got[1], dims[0], dims[1])
+	}
+	return nil
+}
+
+// Dimensions reads the width and height from the JPEG header without
+// decoding the pixels.
+func Dimensions(path string) ([2]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [2]int{}, err
+	}
+	defer f.Close()
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return [2]int{}, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return [2]int{cfg.Width, cfg.Height}, nil
+}
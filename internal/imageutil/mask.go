@@ -0,0 +1,118 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"os"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// LoadMask decodes a grayscale (or color, reduced to luma) mask image.
+// White keeps the full overlay, black removes it.
+func LoadMask(path string) (*image.Gray, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decode mask %s: %w", path, err)
+	}
+	if g, ok := img.(*image.Gray); ok && g.Rect.Min == (image.Point{}) {
+		return g, nil
+	}
+	b := img.Bounds()
+	g := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	xdraw.Draw(g, g.Rect, img, b.Min, xdraw.Src)
+	return g, nil
+}
+
+// FitMask returns a copy of m stretched to width x height, so one mask can
+// be shared by images of any size and edited per image.
+func FitMask(m *image.Gray, width, height int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, width, height))
+	if m.Rect.Dx() == width && m.Rect.Dy() == height {
+		xdraw.Draw(dst, dst.Rect, m, m.Rect.Min, xdraw.Src)
+		return dst
+	}
+	xdraw.ApproxBiLinear.Scale(dst, dst.Rect, m, m.Rect, xdraw.Src, nil)
+	return dst
+}
+
+// RegionMask is white inside r and black elsewhere.
+func RegionMask(width, height int, r image.Rectangle) *image.Gray {
+	m := image.NewGray(image.Rect(0, 0, width, height))
+	r = r.Intersect(m.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := m.Pix[y*m.Stride:]
+		for x := r.Min.X; x < r.Max.X; x++ {
+			row[x] = 255
+		}
+	}
+	return m
+}
+
+// MultiplyMasks combines a and b in place into a.
+func MultiplyMasks(a, b *image.Gray) {
+	for i := range a.Pix {
+		a.Pix[i] = uint8((int(a.Pix[i])*int(b.Pix[i]) + 127) / 255)
+	}
+}
+
+func InvertMask(m *image.Gray) {
+	for i, v := range m.Pix {
+		m.Pix[i] = 255 - v
+	}
+}
+
+// FeatherMask softens mask edges over roughly radius pixels with three box
+// blur passes, a cheap stand-in for a Gaussian.
+func FeatherMask(m *image.Gray, radius int) *image.Gray {
+	if radius <= 0 {
+		return m
+	}
+	r := max(1, radius/2)
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	src := append([]uint8(nil), m.Pix...)
+	tmp := make([]uint8, len(src))
+	for pass := 0; pass < 3; pass++ {
+		boxBlur(src, tmp, w, h, 1, w, r)
+		boxBlur(tmp, src, h, w, w, 1, r)
+	}
+	return &image.Gray{Pix: src, Stride: w, Rect: image.Rect(0, 0, w, h)}
+}
+
+// boxBlur averages each run of n samples (step apart) over a window of
+// 2r+1, for lines lines spaced stride apart; edges are clamped.
+func boxBlur(src, dst []uint8, n, lines, step, stride, r int) {
+	window := 2*r + 1
+	for l := 0; l < lines; l++ {
+		base := l * stride
+		at := func(i int) int {
+			return int(src[base+min(max(i, 0), n-1)*step])
+		}
+		sum := 0
+		for i := -r; i <= r; i++ {
+			sum += at(i)
+		}
+		for i := 0; i < n; i++ {
+			dst[base+i*step] = uint8((sum + window/2) / window)
+			sum += at(i+r+1) - at(i-r)
+		}
+	}
+}
+
+// MaskLayer scales the opacity of layer by the mask value at each pixel.
+func MaskLayer(layer Layer, m *image.Gray, bounds image.Rectangle) Layer {
+	return func(x, y int) ([3]float64, float64) {
+		v := m.Pix[(y-bounds.Min.Y)*m.Stride+(x-bounds.Min.X)]
+		if v == 0 {
+			return [3]float64{}, 0
+		}
+		c, alpha := layer(x, y)
+		return c, alpha * float64(v) / 255
+	}
+}
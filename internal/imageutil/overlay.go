@@ -1,21 +1,81 @@
 package imageutil
 
-import "image"
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
 
 const defaultOverlayAlpha = 0.2
 
+// A Layer returns the color (0..255 per channel) and opacity (0..1)
+// composited over the pixel at x, y.
+type Layer func(x, y int) ([3]float64, float64)
+
+// Composite blends layer over img (source-over). Results are truncated like
+// the original black overlay, so black keeps producing identical pixels.
+func Composite(img *image.NRGBA, layer Layer) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[(y-b.Min.Y)*img.Stride:]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c, alpha := layer(x, y)
+			if alpha <= 0 {
+				continue
+			}
+			if alpha > 1 {
+				alpha = 1
+			}
+			scale := 1 - alpha
+			px := row[(x-b.Min.X)*4:]
+			px[0] = uint8(float64(px[0])*scale + c[0]*alpha)
+			px[1] = uint8(float64(px[1])*scale + c[1]*alpha)
+			px[2] = uint8(float64(px[2])*scale + c[2]*alpha)
+		}
+	}
+}
+
+func SolidLayer(c color.NRGBA, alpha float64) Layer {
+	rgb := rgbOf(c)
+	return func(int, int) ([3]float64, float64) {
+		return rgb, alpha
+	}
+}
+
 func ApplyBlackOverlay(img *image.NRGBA, alpha float64) {
+	ApplyColorOverlay(img, color.NRGBA{A: 255}, alpha)
+}
+
+// ApplyColorOverlay composites c over img at the given opacity.
+func ApplyColorOverlay(img *image.NRGBA, c color.NRGBA, alpha float64) {
 	if alpha <= 0 {
 		return
 	}
-	if alpha > 1 {
-		alpha = 1
+	Composite(img, SolidLayer(c, alpha))
+}
+
+func rgbOf(c color.NRGBA) [3]float64 {
+	return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+}
+
+// ParseHexColor accepts #RRGGBB or the #RGB shorthand, with or without "#".
+func ParseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("color %q: want #RRGGBB", s)
 	}
-	scale := 1 - alpha
-	pix := img.Pix
-	for i := 0; i < len(pix); i += 4 {
-		pix[i+0] = uint8(float64(pix[i+0]) * scale)
-		pix[i+1] = uint8(float64(pix[i+1]) * scale)
-		pix[i+2] = uint8(float64(pix[i+2]) * scale)
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("color %q: want #RRGGBB", s)
 	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+func FormatHexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
 }
@@ -0,0 +1,86 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// saliencyMap scores every pixel of a (downscaled) image by how likely it
+// is to belong to the subject. Edge density and local luma entropy are each
+// normalised to a mean of 1 so neither dominates because of its units; skin
+// tones add a fixed bonus because products are often shown in hands and
+// people should not lose their heads to a crop.
+func saliencyMap(img *image.NRGBA) []float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	luma := lumaOf(img)
+	edges := normalise(boxMean(edgeMap(luma, w, h), w, h, 2))
+	entropy := normalise(blockEntropy(luma, w, h, 8))
+
+	scores := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			scores[i] = edges[i] + entropy[i]
+			if px := row[x*4:]; isSkin(px[0], px[1], px[2]) {
+				scores[i] += 2
+			}
+		}
+	}
+	return scores
+}
+
+// isSkin is the classic YCbCr box test; it is crude but cheap and works
+// across skin tones because it ignores luma.
+func isSkin(r, g, b uint8) bool {
+	y, cb, cr := color.RGBToYCbCr(r, g, b)
+	return y > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+}
+
+// boxMean averages values over a (2r+1)² neighbourhood clipped to the image.
+func boxMean(values []float64, w, h, r int) []float64 {
+	sat := newSummedArea(values, w, h)
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-r, 0), min(y+r+1, h)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-r, 0), min(x+r+1, w)
+			out[y*w+x] = sat.sum(x0, y0, x1-x0, y1-y0) / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return out
+}
+
+// blockEntropy gives each pixel the luma entropy of its size x size block.
+func blockEntropy(luma []float64, w, h, size int) []float64 {
+	out := make([]float64, w*h)
+	for by := 0; by < h; by += size {
+		bh := min(size, h-by)
+		for bx := 0; bx < w; bx += size {
+			bw := min(size, w-bx)
+			e := windowEntropy(luma, w, bx, by, bw, bh)
+			for y := by; y < by+bh; y++ {
+				for x := bx; x < bx+bw; x++ {
+					out[y*w+x] = e
+				}
+			}
+		}
+	}
+	return out
+}
+
+func normalise(values []float64) []float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean <= 0 || math.IsNaN(mean) {
+		return values
+	}
+	for i := range values {
+		values[i] /= mean
+	}
+	return values
+}
@@ -0,0 +1,171 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Sharpen is an unsharp mask: pixels move Amount times their difference
+// from a Gaussian blur of Radius (sigma, in pixels), unless that difference
+// is below Threshold levels, which keeps flat areas and noise untouched.
+// Auto derives the settings from how much the image was downscaled.
+type Sharpen struct {
+	Amount    float64
+	Radius    float64
+	Threshold int
+	Auto      bool
+}
+
+// ParseSharpen reads "amount,radius,threshold" (radius and threshold may
+// be omitted) or "auto"; an empty string disables sharpening.
+func ParseSharpen(s string) (Sharpen, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "0", "off":
+		return Sharpen{}, nil
+	case "auto":
+		return Sharpen{Auto: true}, nil
+	}
+	sh := Sharpen{Radius: 1, Threshold: 2}
+	parts := strings.Split(s, ",")
+	if len(parts) > 3 {
+		return sh, fmt.Errorf("sharpen %q: want amount,radius,threshold or auto", s)
+	}
+	var err error
+	if sh.Amount, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil || sh.Amount < 0 || sh.Amount > 5 {
+		return sh, fmt.Errorf("sharpen %q: amount must be between 0 and 5", s)
+	}
+	if len(parts) > 1 {
+		if sh.Radius, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil || sh.Radius <= 0 || sh.Radius > 10 {
+			return sh, fmt.Errorf("sharpen %q: radius must be above 0 and at most 10", s)
+		}
+	}
+	if len(parts) > 2 {
+		if sh.Threshold, err = strconv.Atoi(strings.TrimSpace(parts[2])); err != nil || sh.Threshold < 0 || sh.Threshold > 255 {
+			return sh, fmt.Errorf("sharpen %q: threshold must be between 0 and 255", s)
+		}
+	}
+	return sh, nil
+}
+
+func (s Sharpen) Enabled() bool {
+	return s.Auto || s.Amount > 0
+}
+
+func (s Sharpen) String() string {
+	if s.Auto {
+		return "auto"
+	}
+	return fmt.Sprintf("%g,%g,%d", s.Amount, s.Radius, s.Threshold)
+}
+
+// forScale resolves Auto for an image shrunk by scale (output/input width):
+// no sharpening at full size or when enlarging, more the harder it shrank.
+func (s Sharpen) forScale(scale float64) Sharpen {
+	if !s.Auto {
+		return s
+	}
+	if scale <= 0 || scale >= 0.95 {
+		return Sharpen{}
+	}
+	return Sharpen{
+		Amount:    math.Min(0.8, 0.15+0.25*math.Log2(1/scale)),
+		Radius:    0.8,
+		Threshold: 2,
+	}
+}
+
+// Scale is how much the kept part of the original was shrunk or enlarged.
+func (info *ImageInfo) Scale() float64 {
+	if info.Factor <= 0 {
+		return 1
+	}
+	return info.Factor
+}
+
+// Apply returns a sharpened copy of img; img itself may be shared with
+// other outputs and is never modified. scale feeds Auto.
+func (s Sharpen) Apply(img *image.NRGBA, scale float64) *image.NRGBA {
+	s = s.forScale(scale)
+	if s.Amount <= 0 {
+		return img
+	}
+	blurred := gaussianBlur(img, s.Radius)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	threshold := float64(s.Threshold)
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride:]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			for c := 0; c < 4; c++ {
+				i := x*4 + c
+				v := float64(src[i])
+				if c < 3 {
+					if diff := v - float64(blurred[(y*w+x)*3+c]); math.Abs(diff) >= threshold {
+						v = math.Max(0, math.Min(255, math.Round(v+s.Amount*diff)))
+					}
+				}
+				dst[i] = uint8(v)
+			}
+		}
+	}
+	return out
+}
+
+// gaussianBlur returns the RGB channels of img blurred with a separable
+// Gaussian of the given sigma, as float32 triples.
+func gaussianBlur(img *image.NRGBA, sigma float64) []float32 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	kernel := gaussianKernel(sigma)
+	r := len(kernel) / 2
+
+	rows := make([]float32, w*h*3)
+	for y := 0; y < h; y++ {
+		src := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			var acc [3]float32
+			for k, weight := range kernel {
+				px := src[clampInt(x+k-r, 0, w-1)*4:]
+				acc[0] += weight * float32(px[0])
+				acc[1] += weight * float32(px[1])
+				acc[2] += weight * float32(px[2])
+			}
+			copy(rows[(y*w+x)*3:], acc[:])
+		}
+	}
+
+	out := make([]float32, w*h*3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var acc [3]float32
+			for k, weight := range kernel {
+				i := (clampInt(y+k-r, 0, h-1)*w + x) * 3
+				acc[0] += weight * rows[i]
+				acc[1] += weight * rows[i+1]
+				acc[2] += weight * rows[i+2]
+			}
+			copy(out[(y*w+x)*3:], acc[:])
+		}
+	}
+	return out
+}
+
+func gaussianKernel(sigma float64) []float32 {
+	r := max(1, int(math.Ceil(3*sigma)))
+	kernel := make([]float32, 2*r+1)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i - r)
+		v := math.Exp(-d * d / (2 * sigma * sigma))
+		kernel[i] = float32(v)
+		sum += v
+	}
+	for i := range kernel {
+		kernel[i] /= float32(sum)
+	}
+	return kernel
+}
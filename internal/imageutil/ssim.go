@@ -0,0 +1,63 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+)
+
+// SSIM compares two equally sized images with the structural similarity
+// index over 8x8 blocks (1 means identical). Luma weighs 0.8 and each
+// chroma plane 0.1, so the cost of chroma subsampling still shows up.
+func SSIM(a, b *image.NRGBA) float64 {
+	w := min(a.Rect.Dx(), b.Rect.Dx())
+	h := min(a.Rect.Dy(), b.Rect.Dy())
+	pa, pb := ycbcrPlanes(a, w, h), ycbcrPlanes(b, w, h)
+	return 0.8*planeSSIM(pa[0], pb[0], w, h) + 0.1*planeSSIM(pa[1], pb[1], w, h) + 0.1*planeSSIM(pa[2], pb[2], w, h)
+}
+
+func ycbcrPlanes(img *image.NRGBA, w, h int) [3][]uint8 {
+	planes := [3][]uint8{make([]uint8, w*h), make([]uint8, w*h), make([]uint8, w*h)}
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			px := row[x*4:]
+			i := y*w + x
+			planes[0][i], planes[1][i], planes[2][i] = color.RGBToYCbCr(px[0], px[1], px[2])
+		}
+	}
+	return planes
+}
+
+func planeSSIM(a, b []uint8, w, h int) float64 {
+	const (
+		block = 8
+		c1    = (0.01 * 255) * (0.01 * 255)
+		c2    = (0.03 * 255) * (0.03 * 255)
+	)
+	total, blocks := 0.0, 0
+	for by := 0; by+block <= h; by += block {
+		for bx := 0; bx+block <= w; bx += block {
+			var sa, sb, saa, sbb, sab float64
+			for y := by; y < by+block; y++ {
+				for x := bx; x < bx+block; x++ {
+					va, vb := float64(a[y*w+x]), float64(b[y*w+x])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			n := float64(block * block)
+			ma, mb := sa/n, sb/n
+			varA, varB := saa/n-ma*ma, sbb/n-mb*mb
+			cov := sab/n - ma*mb
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (varA + varB + c2))
+			blocks++
+		}
+	}
+	if blocks == 0 {
+		return 1
+	}
+	return total / float64(blocks)
+}
@@ -0,0 +1,45 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Vignette darkens (or tints with Color) towards the edges. Distances are
+// measured on an ellipse fitted to the frame, so the falloff keeps the
+// image's proportions; Roundness 1 turns it into a circle of the same area,
+// which suits panoramic banners better. Radius is where the effect starts
+// (1 is the corners) and Softness how much further out it reaches Strength.
+type Vignette struct {
+	Strength  float64
+	Radius    float64
+	Softness  float64
+	Roundness float64
+	Color     color.NRGBA
+}
+
+func (v Vignette) Layer(bounds image.Rectangle) Layer {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	cx, cy := w/2, h/2
+	circle := math.Sqrt(w*h) / 2
+	ax := cx + (circle-cx)*v.Roundness
+	ay := cy + (circle-cy)*v.Roundness
+	// Normalise so the corners sit at distance 1 whatever the roundness.
+	corner := math.Hypot(cx/ax, cy/ay)
+	rgb := rgbOf(v.Color)
+	softness := math.Max(v.Softness, 1e-3)
+
+	return func(x, y int) ([3]float64, float64) {
+		dx := (float64(x-bounds.Min.X) + 0.5 - cx) / ax
+		dy := (float64(y-bounds.Min.Y) + 0.5 - cy) / ay
+		t := (math.Hypot(dx, dy)/corner - v.Radius) / softness
+		if t <= 0 {
+			return rgb, 0
+		}
+		if t > 1 {
+			t = 1
+		}
+		return rgb, v.Strength * t * t * (3 - 2*t)
+	}
+}
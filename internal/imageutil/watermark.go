@@ -0,0 +1,181 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+	"math"
+	"os"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// defaultTextSize is the text height in pixels when the mark is not scaled.
+const defaultTextSize = 48
+
+// anchors maps an anchor name to its position as fractions of the free
+// space left of and above the mark.
+var anchors = map[string][2]float64{
+	"top-left":     {0, 0},
+	"top":          {0.5, 0},
+	"top-right":    {1, 0},
+	"left":         {0, 0.5},
+	"center":       {0.5, 0.5},
+	"right":        {1, 0.5},
+	"bottom-left":  {0, 1},
+	"bottom":       {0.5, 1},
+	"bottom-right": {1, 1},
+}
+
+func ValidateAnchor(name string) error {
+	if _, ok := anchors[name]; !ok {
+		return fmt.Errorf("unknown anchor %q (want top-left, top, top-right, left, center, right, bottom-left, bottom or bottom-right)", name)
+	}
+	return nil
+}
+
+// Watermark is a logo or a line of text placed on every image. Scale is
+// the mark width as a fraction of the image width (0 keeps the logo's own
+// size, or defaultTextSize for text). Margin is in pixels, or a fraction of
+// the image width when MarginRelative is set; tiled marks are spaced by it.
+type Watermark struct {
+	Logo           *image.NRGBA
+	Text           string
+	Font           *opentype.Font
+	TextColor      color.NRGBA
+	Anchor         string
+	Margin         float64
+	MarginRelative bool
+	Scale          float64
+	Opacity        float64
+	Tile           bool
+}
+
+// LoadLogo decodes a PNG (or JPEG) logo, keeping its alpha channel.
+func LoadLogo(path string) (*image.NRGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return toNRGBA(img), nil
+}
+
+// LoadFont parses a TrueType/OpenType font; an empty path selects the
+// embedded Go Regular font.
+func LoadFont(path string) (*opentype.Font, error) {
+	data := goregular.TTF
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse font %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (w Watermark) Layer(bounds image.Rectangle) (Layer, error) {
+	width := bounds.Dx()
+	mark, err := w.mark(width)
+	if err != nil {
+		return nil, err
+	}
+	mw, mh := mark.Rect.Dx(), mark.Rect.Dy()
+
+	margin := w.Margin
+	if w.MarginRelative {
+		margin *= float64(width)
+	}
+	m := int(math.Round(margin))
+	pos := anchors[w.Anchor]
+	ox := m + int(math.Round(pos[0]*float64(width-mw-2*m)))
+	oy := m + int(math.Round(pos[1]*float64(bounds.Dy()-mh-2*m)))
+	stepX, stepY := mw+m, mh+m
+
+	return func(x, y int) ([3]float64, float64) {
+		dx, dy := x-bounds.Min.X-ox, y-bounds.Min.Y-oy
+		if w.Tile {
+			dx = ((dx % stepX) + stepX) % stepX
+			dy = ((dy % stepY) + stepY) % stepY
+		}
+		if dx < 0 || dy < 0 || dx >= mw || dy >= mh {
+			return [3]float64{}, 0
+		}
+		px := mark.Pix[dy*mark.Stride+dx*4:]
+		return [3]float64{float64(px[0]), float64(px[1]), float64(px[2])}, float64(px[3]) / 255 * w.Opacity
+	}, nil
+}
+
+// mark returns the logo or rendered text sized for an image of the given width.
+func (w Watermark) mark(width int) (*image.NRGBA, error) {
+	target := int(math.Round(w.Scale * float64(width)))
+	if w.Logo == nil {
+		size := float64(defaultTextSize)
+		if target > 0 {
+			probe, err := renderText(w.Text, w.Font, size, w.TextColor)
+			if err != nil {
+				return nil, err
+			}
+			size *= float64(target) / float64(probe.Rect.Dx())
+		}
+		return renderText(w.Text, w.Font, size, w.TextColor)
+	}
+
+	lw, lh := w.Logo.Rect.Dx(), w.Logo.Rect.Dy()
+	if target <= 0 || target == lw {
+		return w.Logo, nil
+	}
+	h := max(1, int(math.Round(float64(lh)*float64(target)/float64(lw))))
+	dst := image.NewNRGBA(image.Rect(0, 0, target, h))
+	xdraw.CatmullRom.Scale(dst, dst.Rect, w.Logo, w.Logo.Rect, xdraw.Src, nil)
+	return dst, nil
+}
+
+// renderText draws text (one line per "\n") in c at size pixels on a
+// transparent background cropped to the text.
+func renderText(text string, f *opentype.Font, size float64, c color.NRGBA) (*image.NRGBA, error) {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lines := strings.Split(text, "\n")
+	metrics := face.Metrics()
+	width := 1
+	for _, line := range lines {
+		width = max(width, font.MeasureString(face, line).Ceil())
+	}
+	height := metrics.Height.Ceil()*(len(lines)-1) + (metrics.Ascent + metrics.Descent).Ceil()
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, max(1, height)))
+	d := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+	for i, line := range lines {
+		d.Dot = fixed.Point26_6{Y: metrics.Ascent + metrics.Height*fixed.Int26_6(i)}
+		d.DrawString(line)
+	}
+
+	out := image.NewNRGBA(mask.Rect)
+	for i, a := range mask.Pix {
+		out.Pix[i*4+0] = c.R
+		out.Pix[i*4+1] = c.G
+		out.Pix[i*4+2] = c.B
+		out.Pix[i*4+3] = a
+	}
+	return out, nil
+}
@@ -10,14 +10,29 @@ import (
 	"path/filepath"
 )
 
+// EncodeOptions are passed to cjpeg. The zero value of every field other
+// than Quality keeps cjpeg's default; Sample, DCScanOpt and QuantTable are
+// strings so "unset" stays distinct from a valid 0.
 type EncodeOptions struct {
-	Quality int
+	Quality     int
+	Sample      string
+	Tune        string
+	NoTrellis   bool
+	NoTrellisDC bool
+	Smooth      int
+	DCScanOpt   string
+	Baseline    bool
+	Arithmetic  bool
+	QuantTable  string
 }
 
 func EncodePPM(ctx context.Context, tc *Toolchain, ppmPath, destination string, opts EncodeOptions) (int64, error) {
 	if tc == nil {
 		return 0, fmt.Errorf("toolchain is nil")
 	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
 		return 0, err
@@ -38,19 +53,16 @@ func EncodePPM(ctx context.Context, tc *Toolchain, ppmPath, destination string,
 	}
 	defer in.Close()
 
-	cmd := exec.CommandContext(
-		ctx,
-		tc.CJPEG,
-		"-quality", fmt.Sprintf("%d", opts.Quality),
-		"-optimize",
-		"-progressive",
-	)
+	cmd := exec.CommandContext(ctx, tc.CJPEG, opts.args()...)
 	cmd.Stdin = in
 	cmd.Stdout = out
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
 		return 0, fmt.Errorf("cjpeg failed: %w (%s)", err, stderr.String())
 	}
 
@@ -0,0 +1,98 @@
+package mozjpeg
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+var (
+	samplings = []string{"1x1", "2x1", "1x2", "2x2"}
+	tunings   = []string{"ssim", "psnr", "hvs-psnr", "ms-ssim"}
+)
+
+// RegisterEncodeFlags adds the cjpeg tuning flags shared by every command
+// that encodes. Quality is set per attempt by the caller.
+func RegisterEncodeFlags(fs *flag.FlagSet) *EncodeOptions {
+	o := &EncodeOptions{}
+	fs.StringVar(&o.Sample, "sample", "", "Chroma subsampling: 2x2 (4:2:0, default), 2x1 (4:2:2) or 1x1 (4:4:4).")
+	fs.StringVar(&o.Tune, "tune", "", "Optimise quantisation for ssim, psnr, hvs-psnr or ms-ssim (default: cjpeg's own).")
+	fs.BoolVar(&o.NoTrellis, "no-trellis", false, "Disable trellis quantisation of AC coefficients.")
+	fs.BoolVar(&o.NoTrellisDC, "no-trellis-dc", false, "Disable trellis quantisation of DC coefficients.")
+	fs.IntVar(&o.Smooth, "smooth", 0, "Smooth the input by this factor (1..100) before encoding; helps dithered images.")
+	fs.StringVar(&o.DCScanOpt, "dc-scan-opt", "", "DC scan optimisation mode 0, 1 or 2 (default: cjpeg's own).")
+	fs.BoolVar(&o.Baseline, "baseline", false, "Write baseline instead of progressive JPEGs.")
+	fs.BoolVar(&o.Arithmetic, "arithmetic", false, "Use arithmetic coding (smaller, but many browsers cannot decode it).")
+	fs.StringVar(&o.QuantTable, "quant-table", "", "Quantisation table preset 0..8 (default: cjpeg's own).")
+	return o
+}
+
+func (o EncodeOptions) Validate() error {
+	if o.Sample != "" && !oneOf(o.Sample, samplings) {
+		return fmt.Errorf("unknown sampling %q (want 1x1, 2x1, 1x2 or 2x2)", o.Sample)
+	}
+	if o.Tune != "" && !oneOf(o.Tune, tunings) {
+		return fmt.Errorf("unknown tune metric %q (want ssim, psnr, hvs-psnr or ms-ssim)", o.Tune)
+	}
+	if o.Smooth < 0 || o.Smooth > 100 {
+		return fmt.Errorf("smooth must be between 0 and 100")
+	}
+	if o.DCScanOpt != "" && !inRange(o.DCScanOpt, 0, 2) {
+		return fmt.Errorf("dc-scan-opt must be 0, 1 or 2")
+	}
+	if o.QuantTable != "" && !inRange(o.QuantTable, 0, 8) {
+		return fmt.Errorf("quant-table must be between 0 and 8")
+	}
+	return nil
+}
+
+func (o EncodeOptions) args() []string {
+	args := []string{"-quality", strconv.Itoa(o.Quality)}
+	if !o.Arithmetic {
+		args = append(args, "-optimize")
+	}
+	if o.Baseline {
+		args = append(args, "-baseline")
+	} else {
+		args = append(args, "-progressive")
+	}
+	if o.Arithmetic {
+		args = append(args, "-arithmetic")
+	}
+	if o.Sample != "" {
+		args = append(args, "-sample", o.Sample)
+	}
+	if o.Tune != "" {
+		args = append(args, "-tune-"+o.Tune)
+	}
+	if o.NoTrellis {
+		args = append(args, "-notrellis")
+	}
+	if o.NoTrellisDC {
+		args = append(args, "-notrellis-dc")
+	}
+	if o.Smooth > 0 {
+		args = append(args, "-smooth", strconv.Itoa(o.Smooth))
+	}
+	if o.DCScanOpt != "" {
+		args = append(args, "-dc-scan-opt", o.DCScanOpt)
+	}
+	if o.QuantTable != "" {
+		args = append(args, "-quant-table", o.QuantTable)
+	}
+	return args
+}
+
+func oneOf(v string, list []string) bool {
+	for _, s := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func inRange(v string, lo, hi int) bool {
+	n, err := strconv.Atoi(v)
+	return err == nil && n >= lo && n <= hi
+}
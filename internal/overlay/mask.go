@@ -0,0 +1,197 @@
+package overlay
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yegorkir/jpgtools/internal/common"
+	"github.com/yegorkir/jpgtools/internal/imageutil"
+)
+
+// length is a size in pixels or, when relative, a fraction of an image side.
+type length struct {
+	v        float64
+	relative bool
+}
+
+func parseLength(s string) (length, error) {
+	var l length
+	value := strings.TrimSpace(s)
+	if strings.HasSuffix(value, "%") {
+		value, l.relative = strings.TrimSuffix(value, "%"), true
+	} else {
+		value = strings.TrimSuffix(value, "px")
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 {
+		return l, fmt.Errorf("%q: want pixels or a percentage such as 2%%", s)
+	}
+	if l.relative {
+		v /= 100
+	}
+	l.v = v
+	return l, nil
+}
+
+func (l length) px(side int) int {
+	if l.relative {
+		return int(math.Round(l.v * float64(side)))
+	}
+	return int(math.Round(l.v))
+}
+
+var maskExts = []string{".png", ".PNG", ".jpg", ".JPG", ".jpeg", ".JPEG"}
+
+// maskOptions builds the per-image mask from --mask, --region, --feather
+// and --invert-mask.
+type maskOptions struct {
+	path    string
+	dir     bool
+	image   *image.Gray
+	hash    string
+	region  []length
+	feather length
+	invert  bool
+	spec    string
+	desc    string
+}
+
+func parseMask(path, region, feather string, invert bool) (*maskOptions, error) {
+	if path == "" && region == "" {
+		if feather != "0" || invert {
+			return nil, fmt.Errorf("--feather and --invert-mask require --mask or --region")
+		}
+		return nil, nil
+	}
+	m := &maskOptions{path: path, invert: invert}
+	var err error
+	if m.feather, err = parseLength(feather); err != nil {
+		return nil, fmt.Errorf("feather %w", err)
+	}
+	if region != "" {
+		parts := strings.Split(region, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("region %q: want x,y,w,h", region)
+		}
+		for _, part := range parts {
+			l, err := parseLength(part)
+			if err != nil {
+				return nil, fmt.Errorf("region %w", err)
+			}
+			m.region = append(m.region, l)
+		}
+	}
+	if path != "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if m.dir = info.IsDir(); !m.dir {
+			if m.image, err = imageutil.LoadMask(path); err != nil {
+				return nil, err
+			}
+			if m.hash, err = common.HashFile(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	m.spec = fmt.Sprintf("%s|%s|%s|%t", path, region, feather, invert)
+	var desc []string
+	if path != "" {
+		desc = append(desc, "mask="+path)
+	}
+	if region != "" {
+		desc = append(desc, "region="+region)
+	}
+	if m.feather.v > 0 {
+		desc = append(desc, "feather="+feather)
+	}
+	if invert {
+		desc = append(desc, "inverted")
+	}
+	m.desc = strings.Join(desc, " ")
+	return m, nil
+}
+
+// key identifies the mask settings, including a single mask's content, in
+// the options hash.
+func (m *maskOptions) key() string {
+	if m == nil {
+		return ""
+	}
+	if m.hash != "" {
+		return m.spec + "|" + m.hash[:8]
+	}
+	return m.spec
+}
+
+// sourceKey identifies the mask a directory holds for src by its content,
+// so editing that one mask invalidates the source in the manifest.
+func (m *maskOptions) sourceKey(src, input string) string {
+	path, err := m.find(src, input)
+	if err != nil {
+		return ""
+	}
+	sum, err := common.HashFile(path)
+	if err != nil {
+		return ""
+	}
+	return path + "|" + sum[:8]
+}
+
+func (m *maskOptions) build(src, input string, width, height int) (*image.Gray, error) {
+	var mask *image.Gray
+	if m.path != "" {
+		g := m.image
+		if m.dir {
+			path, err := m.find(src, input)
+			if err != nil {
+				return nil, err
+			}
+			if g, err = imageutil.LoadMask(path); err != nil {
+				return nil, err
+			}
+		}
+		mask = imageutil.FitMask(g, width, height)
+	}
+	if m.region != nil {
+		x, y := m.region[0].px(width), m.region[1].px(height)
+		r := imageutil.RegionMask(width, height, image.Rect(x, y, x+m.region[2].px(width), y+m.region[3].px(height)))
+		if mask == nil {
+			mask = r
+		} else {
+			imageutil.MultiplyMasks(mask, r)
+		}
+	}
+	if m.invert {
+		imageutil.InvertMask(mask)
+	}
+	return imageutil.FeatherMask(mask, m.feather.px(width)), nil
+}
+
+// find looks for a mask mirroring the source's path below --input, then
+// for one with the same base name at the top of the mask directory.
+func (m *maskOptions) find(src, input string) (string, error) {
+	name := filepath.Base(src)
+	candidates := []string{name}
+	if rel, err := filepath.Rel(input, src); err == nil && !strings.HasPrefix(rel, "..") && rel != name {
+		candidates = append([]string{rel}, candidates...)
+	}
+	srcInfo, _ := os.Stat(src)
+	for _, c := range candidates {
+		stem := strings.TrimSuffix(c, filepath.Ext(c))
+		for _, ext := range maskExts {
+			path := filepath.Join(m.path, stem+ext)
+			// Masks kept next to the sources must not match the photo itself.
+			if info, err := os.Stat(path); err == nil && (srcInfo == nil || !os.SameFile(info, srcInfo)) {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no mask for %s in %s", name, m.path)
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"image/color"
 	"os"
 	"path/filepath"
-	"time"
+	"strconv"
+	"strings"
 
 	"github.com/yegorkir/jpgtools/internal/common"
 	"github.com/yegorkir/jpgtools/internal/imageutil"
@@ -14,67 +16,158 @@ import (
 )
 
 type options struct {
-	Input     string
-	Output    string
-	Recursive bool
-	Overwrite bool
-	DryRun    bool
-	Quality   int
-	Alpha     float64
+	common.BatchOptions
+	Quality int
+	Encode  mozjpeg.EncodeOptions
+	Alpha   float64
+	Color   color.NRGBA
+	// Gradient replaces the flat tint when it has stops.
+	Gradient imageutil.Gradient
+	// Vignette replaces the flat tint when its strength is positive.
+	Vignette imageutil.Vignette
+	Blend    string
+	// Mask limits the tint or gradient per pixel; nil applies it everywhere.
+	Mask *maskOptions
+	// Watermark is nil unless --watermark or --text is set; markHash stands
+	// in for it in the options hash.
+	Watermark *imageutil.Watermark
+	markHash  string
+	Naming    *common.Naming
 }
 
-func Run(args []string) error {
+func Run(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("overlay", flag.ContinueOnError)
-	input := fs.String("input", ".", "Directory with source JPEGs.")
-	fs.StringVar(input, "i", ".", "Directory with source JPEGs.")
-	output := fs.String("output", "", "Destination directory (default: ./output_YYMMDDhhmm).")
-	fs.StringVar(output, "o", "", "Destination directory (default: ./output_YYMMDDhhmm).")
-	recursive := fs.Bool("recursive", false, "Recurse into subdirectories.")
-	overwrite := fs.Bool("overwrite", false, "Overwrite files in the output directory.")
-	dryRun := fs.Bool("dry-run", false, "Preview work without touching files.")
+	batchOpts := common.RegisterBatchFlags(fs)
+	common.RegisterInPlaceFlags(fs, batchOpts)
 	quality := fs.Int("quality", 95, "mozjpeg quality for the re-encoded image.")
 	alpha := fs.Float64("alpha", 0.2, "Overlay opacity (0..1).")
+	tint := fs.String("color", "#000000", "Overlay color as #RRGGBB.")
+	gradient := fs.String("gradient", "", "Replace the flat tint with a linear or radial gradient.")
+	angle := fs.Float64("angle", 180, "Linear gradient direction in degrees (0 up, 90 right, 180 down).")
+	center := fs.String("center", "0.5,0.5", "Radial gradient centre as x,y fractions of the image.")
+	startAlpha := fs.Float64("start-alpha", 0, "Gradient opacity at its start.")
+	endAlpha := fs.Float64("end-alpha", 0.6, "Gradient opacity at its end.")
+	easing := fs.String("easing", "linear", "Gradient easing: linear, ease-in, ease-out or ease-in-out.")
+	vignette := fs.Float64("vignette", 0, "Replace the flat tint with a vignette of this strength (0..1).")
+	vignetteRadius := fs.Float64("vignette-radius", 0.5, "Where the vignette starts, from 0 (centre) to 1 (corners).")
+	vignetteSoftness := fs.Float64("vignette-softness", 0.5, "How far beyond --vignette-radius the vignette reaches full strength.")
+	vignetteRoundness := fs.Float64("vignette-roundness", 0, "Vignette shape from 0 (follows the frame) to 1 (circle).")
+	blend := fs.String("blend", "normal", "Blend mode for the tint, gradient and watermark: normal, multiply, screen, overlay, soft-light or color.")
+	var stops common.ListFlag
+	fs.Var(&stops, "stop", "Gradient stop pos:#RRGGBB@alpha, pos in 0..1 or % (repeatable; replaces --start-alpha/--end-alpha).")
+	maskPath := fs.String("mask", "", "Grayscale mask (white keeps the overlay), or a directory of masks named like the sources.")
+	region := fs.String("region", "", "Only overlay the rectangle x,y,w,h, each in px or % of the image.")
+	feather := fs.String("feather", "0", "Soften mask and region edges over this many px or % of the image width.")
+	invertMask := fs.Bool("invert-mask", false, "Overlay where the mask is black or outside --region instead.")
+	logo := fs.String("watermark", "", "PNG logo (with alpha) composited over every image.")
+	text := fs.String("text", "", "Text composited over every image instead of a logo.")
+	fontPath := fs.String("font", "", "TrueType/OpenType font for --text (default: embedded Go Regular).")
+	textColor := fs.String("text-color", "#ffffff", "Color of --text as #RRGGBB.")
+	anchor := fs.String("anchor", "bottom-right", "Watermark position: top-left, top, top-right, left, center, right, bottom-left, bottom or bottom-right.")
+	margin := fs.String("margin", "2%", "Watermark distance from the edges (and between tiles) in px or % of the image width.")
+	scale := fs.Float64("scale", 0.2, "Watermark width as a fraction of the image width (0: logo at its own size, text at 48px).")
+	opacity := fs.Float64("opacity", 0.5, "Watermark opacity (0..1).")
+	tile := fs.Bool("tile", false, "Repeat the watermark across the whole image.")
+	encode := mozjpeg.RegisterEncodeFlags(fs)
+	naming := common.RegisterNamingFlags(fs, "{dir}/{name}.{ext}")
+	config := common.RegisterConfigFlags(fs)
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if printed, err := config.Apply(fs, "overlay"); err != nil || printed {
+		return err
+	}
+	if err := batchOpts.Validate(); err != nil {
+		return err
+	}
+	if err := naming.Validate(); err != nil {
+		return err
+	}
+	if batchOpts.InPlace && !naming.Mirrors() {
+		return fmt.Errorf("--in-place cannot be combined with --name-template or --flatten")
+	}
 
 	if *quality <= 0 || *quality > 100 {
 		return fmt.Errorf("quality must be between 1 and 100")
 	}
+	if err := encode.Validate(); err != nil {
+		return err
+	}
+	if batchOpts.InPlace && encode.Arithmetic {
+		return fmt.Errorf("--in-place cannot verify arithmetic-coded JPEGs; drop --arithmetic")
+	}
 	if *alpha < 0 || *alpha > 1 {
 		return fmt.Errorf("alpha must be between 0 and 1")
 	}
-
-	opt := options{
-		Input:     *input,
-		Recursive: *recursive,
-		Overwrite: *overwrite,
-		DryRun:    *dryRun,
-		Quality:   *quality,
-		Alpha:     *alpha,
+	overlayColor, err := imageutil.ParseHexColor(*tint)
+	if err != nil {
+		return err
+	}
+	vig := imageutil.Vignette{Strength: *vignette, Radius: *vignetteRadius, Softness: *vignetteSoftness, Roundness: *vignetteRoundness, Color: overlayColor}
+	switch {
+	case *vignette < 0 || *vignette > 1:
+		return fmt.Errorf("vignette strength must be between 0 and 1")
+	case *vignetteRadius < 0 || *vignetteRadius > 1 || *vignetteSoftness < 0 || *vignetteSoftness > 1:
+		return fmt.Errorf("vignette radius and softness must be between 0 and 1")
+	case *vignetteRoundness < 0 || *vignetteRoundness > 1:
+		return fmt.Errorf("vignette roundness must be between 0 and 1")
+	case *vignette > 0 && *gradient != "":
+		return fmt.Errorf("use either --vignette or --gradient, not both")
+	case *vignette == 0:
+		vig = imageutil.Vignette{}
+	}
+	if err := imageutil.ValidateBlend(*blend); err != nil {
+		return err
+	}
+	var grad imageutil.Gradient
+	if *gradient != "" {
+		if grad, err = parseGradient(*gradient, *angle, *center, *startAlpha, *endAlpha, *easing, stops, overlayColor); err != nil {
+			return err
+		}
+	} else if len(stops) > 0 {
+		return fmt.Errorf("--stop requires --gradient linear or radial")
 	}
 
-	out, err := common.ResolveOutputDir(*output)
+	mask, err := parseMask(*maskPath, *region, *feather, *invertMask)
+	if err != nil {
+		return err
+	}
+	mark, markHash, err := parseWatermark(*logo, *text, *fontPath, *textColor, *anchor, *margin, *scale, *opacity, *tile)
 	if err != nil {
 		return err
 	}
-	opt.Output = out
 
-	if err := common.EnsureOutputDir(out, opt.Overwrite, opt.DryRun); err != nil {
+	defer batchOpts.Cleanup()
+	if err := batchOpts.PrepareOutput(); err != nil {
+		return err
+	}
+	if err := batchOpts.PrepareInput(fs.Args()); err != nil {
 		return err
 	}
+	opt := options{
+		BatchOptions: *batchOpts,
+		Quality:      *quality,
+		Encode:       *encode,
+		Alpha:        *alpha,
+		Color:        overlayColor,
+		Gradient:     grad,
+		Vignette:     vig,
+		Blend:        *blend,
+		Mask:         mask,
+		Watermark:    mark,
+		markHash:     markHash,
+		Naming:       naming,
+	}
 
-	files, err := common.CollectJPEGs(opt.Input, opt.Recursive)
+	files, err := opt.Collect()
 	if err != nil {
 		return err
 	}
-	if len(files) == 0 {
-		fmt.Printf("No JPEG files found in %s.\n", opt.Input)
+	if opt.NothingToDo(files) {
 		return nil
 	}
 
-	ctx := context.Background()
 	var tc *mozjpeg.Toolchain
 	if !opt.DryRun {
 		tc, err = mozjpeg.Ensure(ctx)
@@ -86,66 +179,245 @@ func Run(args []string) error {
 		fmt.Println("Running in dry-run mode. No files will be written.")
 	}
 
-	start := time.Now()
-	for _, src := range files {
-		rel, err := filepath.Rel(opt.Input, src)
+	batch := opt.Batch(opt.hash())
+	batch.Naming = opt.Naming
+	if opt.Mask != nil && opt.Mask.dir {
+		batch.SourceOptions = func(src string) string { return opt.Mask.sourceKey(src, opt.Input) }
+	}
+	return batch.Run(ctx, files, func(ctx context.Context, src, dest string) (common.FileResult, error) {
+		return processFile(ctx, tc, src, dest, opt)
+	})
+}
+
+func (o options) hash() string {
+	return common.HashOptions("overlay", o.Quality, o.Encode, o.Alpha, o.Color, o.Gradient, o.Vignette, o.Blend, o.Mask.key(), o.markHash, o.Naming.Template, o.Naming.Flatten)
+}
+
+func parseGradient(kind string, angle float64, center string, startAlpha, endAlpha float64, easing string, stops []string, c color.NRGBA) (imageutil.Gradient, error) {
+	g := imageutil.Gradient{Angle: angle, Easing: easing}
+	switch kind {
+	case "linear":
+	case "radial":
+		g.Radial = true
+		x, y, ok := strings.Cut(center, ",")
+		cx, errX := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		cy, errY := strconv.ParseFloat(strings.TrimSpace(y), 64)
+		if !ok || errX != nil || errY != nil {
+			return g, fmt.Errorf("center %q: want x,y fractions such as 0.5,0.5", center)
+		}
+		g.Center = [2]float64{cx, cy}
+	default:
+		return g, fmt.Errorf("unknown gradient %q (want linear or radial)", kind)
+	}
+	if err := imageutil.ValidateEasing(easing); err != nil {
+		return g, err
+	}
+	if startAlpha < 0 || startAlpha > 1 || endAlpha < 0 || endAlpha > 1 {
+		return g, fmt.Errorf("gradient alphas must be between 0 and 1")
+	}
+
+	if len(stops) == 0 {
+		g.Stops = []imageutil.GradientStop{
+			{Pos: 0, Color: c, Alpha: startAlpha},
+			{Pos: 1, Color: c, Alpha: endAlpha},
+		}
+		return g, nil
+	}
+	for _, spec := range stops {
+		stop, err := imageutil.ParseGradientStop(spec, c, endAlpha)
 		if err != nil {
-			rel = filepath.Base(src)
+			return g, err
+		}
+		g.Stops = append(g.Stops, stop)
+	}
+	return g, nil
+}
+
+// parseWatermark loads the logo or font up front so a bad file fails the
+// run before any image is touched. The returned hash covers the logo and
+// font contents, so replacing either reprocesses resumed runs.
+func parseWatermark(logo, text, fontPath, textColor, anchor, margin string, scale, opacity float64, tile bool) (*imageutil.Watermark, string, error) {
+	if logo == "" && text == "" {
+		return nil, "", nil
+	}
+	if logo != "" && text != "" {
+		return nil, "", fmt.Errorf("use either --watermark or --text, not both")
+	}
+	if err := imageutil.ValidateAnchor(anchor); err != nil {
+		return nil, "", err
+	}
+	if scale < 0 || scale > 1 {
+		return nil, "", fmt.Errorf("scale must be between 0 and 1")
+	}
+	if opacity < 0 || opacity > 1 {
+		return nil, "", fmt.Errorf("opacity must be between 0 and 1")
+	}
+	m, err := parseLength(margin)
+	if err != nil {
+		return nil, "", fmt.Errorf("margin %w", err)
+	}
+	w := &imageutil.Watermark{Anchor: anchor, Margin: m.v, MarginRelative: m.relative, Scale: scale, Opacity: opacity, Tile: tile}
+
+	var source string
+	if logo != "" {
+		if w.Logo, err = imageutil.LoadLogo(logo); err != nil {
+			return nil, "", err
 		}
-		dest := filepath.Join(opt.Output, rel)
-		if err := processFile(ctx, tc, src, dest, opt); err != nil {
-			fmt.Printf("[ERROR] %s: %v\n", src, err)
+		if source, err = common.HashFile(logo); err != nil {
+			return nil, "", err
+		}
+	} else {
+		w.Text = text
+		if w.TextColor, err = imageutil.ParseHexColor(textColor); err != nil {
+			return nil, "", err
+		}
+		if w.Font, err = imageutil.LoadFont(fontPath); err != nil {
+			return nil, "", err
+		}
+		if fontPath != "" {
+			if source, err = common.HashFile(fontPath); err != nil {
+				return nil, "", err
+			}
 		}
 	}
+	return w, common.HashOptions(source, w.Text, w.TextColor, anchor, w.Margin, w.MarginRelative, scale, opacity, tile), nil
+}
 
-	fmt.Printf("Done in %s.\n", time.Since(start).Truncate(time.Millisecond))
-	return nil
+func (o options) describe() string {
+	desc := fmt.Sprintf("color=%s alpha=%.2f", imageutil.FormatHexColor(o.Color), o.Alpha)
+	if len(o.Gradient.Stops) > 0 {
+		kind := fmt.Sprintf("linear %.0f°", o.Gradient.Angle)
+		if o.Gradient.Radial {
+			kind = fmt.Sprintf("radial at %.2f,%.2f", o.Gradient.Center[0], o.Gradient.Center[1])
+		}
+		desc = fmt.Sprintf("gradient=%s stops=%d easing=%s", kind, len(o.Gradient.Stops), o.Gradient.Easing)
+	}
+	if v := o.Vignette; v.Strength > 0 {
+		desc = fmt.Sprintf("vignette=%.2f radius=%.2f softness=%.2f roundness=%.2f color=%s",
+			v.Strength, v.Radius, v.Softness, v.Roundness, imageutil.FormatHexColor(v.Color))
+	}
+	if o.Mask != nil {
+		desc += " " + o.Mask.desc
+	}
+	if o.Blend != "normal" {
+		desc += " blend=" + o.Blend
+	}
+	if w := o.Watermark; w != nil {
+		mark := "logo"
+		if w.Logo == nil {
+			mark = fmt.Sprintf("text=%q", w.Text)
+		}
+		desc += fmt.Sprintf(" watermark=%s anchor=%s opacity=%.2f", mark, w.Anchor, w.Opacity)
+		if w.Tile {
+			desc += " tiled"
+		}
+	}
+	return desc
 }
 
-func processFile(ctx context.Context, tc *mozjpeg.Toolchain, src, dest string, opt options) error {
-	if _, err := os.Stat(dest); err == nil && !opt.Overwrite && !opt.DryRun {
-		fmt.Printf("[SKIP] %s exists (use --overwrite).\n", dest)
-		return nil
-	} else if err != nil && !os.IsNotExist(err) {
-		return err
+func processFile(ctx context.Context, tc *mozjpeg.Toolchain, src, dest string, opt options) (common.FileResult, error) {
+	vars := common.NameVars{Quality: opt.Quality}
+	out, named, err := opt.Naming.Resolve(opt.Output, src, dest, vars)
+	if err != nil {
+		return common.FileResult{}, err
+	}
+	if named {
+		if keep, err := opt.KeepExisting(out); err != nil {
+			return common.FileResult{}, err
+		} else if keep {
+			fmt.Printf("[SKIP] %s exists (use --overwrite).\n", out)
+			return common.FileResult{Dest: out, Status: "SKIP"}, nil
+		}
 	}
 
 	imgInfo, err := imageutil.LoadAndResize(src, imageutil.ResizeBounds{})
 	if err != nil {
-		return err
+		return common.FileResult{}, err
+	}
+	res := common.FileResult{
+		Dest:      out,
+		Original:  imgInfo.Original,
+		Processed: imgInfo.Processed,
+		Quality:   opt.Quality,
+	}
+
+	if !named {
+		vars.Width, vars.Height = imgInfo.Processed[0], imgInfo.Processed[1]
+		if out, _, err = opt.Naming.Resolve(opt.Output, src, dest, vars); err != nil {
+			return res, err
+		}
+		res.Dest = out
+		if keep, err := opt.KeepExisting(out); err != nil {
+			return res, err
+		} else if keep {
+			fmt.Printf("[SKIP] %s exists (use --overwrite).\n", out)
+			res.Status = "SKIP"
+			return res, nil
+		}
 	}
 
 	if opt.DryRun {
-		fmt.Printf("[DRY] overlay %s -> %s (%dx%d) alpha=%.2f quality=%d\n",
+		fmt.Printf("[DRY] overlay %s -> %s (%dx%d) %s quality=%d\n",
 			filepath.Base(src),
-			dest,
+			opt.ShownDest(src, out),
 			imgInfo.Original[0],
 			imgInfo.Original[1],
-			opt.Alpha,
+			opt.describe(),
 			opt.Quality,
 		)
-		return nil
+		res.Status = "DRY"
+		return res, nil
 	}
 
-	imageutil.ApplyBlackOverlay(imgInfo.Image, opt.Alpha)
+	bounds := imgInfo.Image.Bounds()
+	var layer imageutil.Layer
+	if len(opt.Gradient.Stops) > 0 {
+		layer = opt.Gradient.Layer(bounds)
+	} else if opt.Vignette.Strength > 0 {
+		layer = opt.Vignette.Layer(bounds)
+	} else if opt.Alpha > 0 {
+		layer = imageutil.SolidLayer(opt.Color, opt.Alpha)
+	}
+	if layer != nil {
+		if opt.Mask != nil {
+			mask, err := opt.Mask.build(src, opt.Input, bounds.Dx(), bounds.Dy())
+			if err != nil {
+				return res, err
+			}
+			layer = imageutil.MaskLayer(layer, mask, bounds)
+		}
+		imageutil.CompositeBlend(imgInfo.Image, layer, opt.Blend)
+	}
+	if opt.Watermark != nil {
+		layer, err := opt.Watermark.Layer(bounds)
+		if err != nil {
+			return res, fmt.Errorf("watermark: %w", err)
+		}
+		imageutil.CompositeBlend(imgInfo.Image, layer, opt.Blend)
+	}
 
 	ppmPath, err := imageutil.WritePPM(imgInfo.Image)
 	if err != nil {
-		return fmt.Errorf("write ppm: %w", err)
+		return res, fmt.Errorf("write ppm: %w", err)
 	}
 	defer os.Remove(ppmPath)
 
-	size, err := mozjpeg.EncodePPM(ctx, tc, ppmPath, dest, mozjpeg.EncodeOptions{Quality: opt.Quality})
+	enc := opt.Encode
+	enc.Quality = opt.Quality
+	size, err := mozjpeg.EncodePPM(ctx, tc, ppmPath, out, enc)
 	if err != nil {
-		return err
+		return res, err
 	}
 
 	fmt.Printf("[OK] %s -> %s (%dx%d) size=%.1fKB\n",
 		filepath.Base(src),
-		dest,
+		opt.ShownDest(src, out),
 		imgInfo.Original[0],
 		imgInfo.Original[1],
 		float64(size)/1024,
 	)
-	return nil
+	res.Status = "OK"
+	res.Attempts = 1
+	res.BytesOut = size
+	return res, nil
 }
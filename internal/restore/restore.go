@@ -0,0 +1,97 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/yegorkir/jpgtools/internal/common"
+)
+
+func Run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	input := fs.String("input", ".", "Directory processed with --in-place (holds the manifest).")
+	fs.StringVar(input, "i", ".", "Directory processed with --in-place (holds the manifest).")
+	keep := fs.Bool("keep-backups", false, "Copy originals back instead of moving them.")
+	dryRun := fs.Bool("dry-run", false, "Show what would be restored without touching files.")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(*input, common.ManifestName)); err != nil {
+		return fmt.Errorf("no manifest in %s: %w", *input, err)
+	}
+	manifest, err := common.OpenManifest(*input, *dryRun)
+	if err != nil {
+		return fmt.Errorf("open manifest: %w", err)
+	}
+	defer manifest.Close()
+
+	restored, failed := 0, 0
+	for _, e := range manifest.Entries() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if e.Backup == "" {
+			continue
+		}
+		// Sources named outside --input were recorded with absolute paths.
+		target := e.Source
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(*input, target)
+		}
+		backup := e.Backup
+		if !filepath.IsAbs(backup) {
+			backup = filepath.Join(*input, backup)
+		}
+		if *dryRun {
+			fmt.Printf("[DRY] restore %s -> %s\n", backup, target)
+			restored++
+			continue
+		}
+		if err := restoreFile(backup, target, *keep); err != nil {
+			fmt.Printf("[ERROR] %s: %v\n", target, err)
+			failed++
+			continue
+		}
+		// Without a done status the next --incremental run processes the
+		// original again.
+		if err := manifest.Record(common.ManifestEntry{Source: e.Source, Dest: target, Status: "RESTORED"}); err != nil {
+			fmt.Printf("[WARN] %s: update manifest: %v\n", target, err)
+		}
+		fmt.Printf("[RESTORED] %s -> %s\n", backup, target)
+		restored++
+	}
+
+	fmt.Printf("Restored %d files, %d failed.\n", restored, failed)
+	if failed > 0 {
+		return &common.ExitError{Code: common.ExitFailed, Msg: fmt.Sprintf("%d file(s) could not be restored", failed)}
+	}
+	return nil
+}
+
+func restoreFile(backup, target string, keep bool) error {
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("backup %s: %w", backup, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if !keep {
+		// Backups on another filesystem cannot be renamed; copy those instead.
+		if err := os.Rename(backup, target); !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	if err := common.CopyFile(backup, target); err != nil {
+		return err
+	}
+	if keep {
+		return nil
+	}
+	return os.Remove(backup)
+}
@@ -0,0 +1,88 @@
+package variants
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type srcsetEntry struct {
+	Source   string        `json:"source"`
+	Src      string        `json:"src"`
+	Srcset   string        `json:"srcset"`
+	Sizes    string        `json:"sizes"`
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	Variants []variantFile `json:"variants"`
+}
+
+type variantFile struct {
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes,omitempty"`
+}
+
+// add records an output; paths are kept relative to the output directory
+// with forward slashes so the snippet can be pasted into HTML as-is.
+func (e *srcsetEntry) add(root, path string, dims [2]int, size int64) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	e.Variants = append(e.Variants, variantFile{
+		Path:   filepath.ToSlash(rel),
+		Width:  dims[0],
+		Height: dims[1],
+		Bytes:  size,
+	})
+}
+
+func (e *srcsetEntry) finish() {
+	if len(e.Variants) == 0 {
+		return
+	}
+	sort.SliceStable(e.Variants, func(i, j int) bool { return e.Variants[i].Width < e.Variants[j].Width })
+	parts := make([]string, 0, len(e.Variants))
+	for _, v := range e.Variants {
+		parts = append(parts, fmt.Sprintf("%s %dw", v.Path, v.Width))
+	}
+	largest := e.Variants[len(e.Variants)-1]
+	e.Src = largest.Path
+	e.Width = largest.Width
+	e.Height = largest.Height
+	e.Srcset = strings.Join(parts, ", ")
+}
+
+func writeSrcset(path string, entries []srcsetEntry) error {
+	if entries == nil {
+		entries = []srcsetEntry{}
+	}
+	var data []byte
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		var err error
+		data, err = json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	} else {
+		var b strings.Builder
+		for _, e := range entries {
+			fmt.Fprintf(&b, "<!-- %s -->\n", html.EscapeString(filepath.Base(e.Source)))
+			fmt.Fprintf(&b, "<img src=\"%s\" srcset=\"%s\" sizes=\"%s\" width=\"%d\" height=\"%d\" alt=\"\">\n",
+				html.EscapeString(e.Src),
+				html.EscapeString(e.Srcset),
+				html.EscapeString(e.Sizes),
+				e.Width,
+				e.Height,
+			)
+		}
+		data = []byte(b.String())
+	}
+	return os.WriteFile(path, data, 0o644)
+}
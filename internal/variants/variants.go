@@ -0,0 +1,419 @@
+package variants
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yegorkir/jpgtools/internal/common"
+	"github.com/yegorkir/jpgtools/internal/compress"
+	"github.com/yegorkir/jpgtools/internal/imageutil"
+	"github.com/yegorkir/jpgtools/internal/mozjpeg"
+)
+
+var defaultSpecs = []string{
+	"320w:width=320,target-kb=40",
+	"640w:width=640,target-kb=90",
+	"1280w:width=1280,target-kb=200",
+	"2380w:width=2380,target-kb=300",
+}
+
+type variant struct {
+	Suffix string
+	Bounds imageutil.ResizeBounds
+	compress.QualitySearch
+}
+
+type options struct {
+	common.BatchOptions
+	Variants []variant
+	Sharpen  imageutil.Sharpen
+	Naming   *common.Naming
+	Srcset   string
+	Sizes    string
+}
+
+func Run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("variants", flag.ContinueOnError)
+	batchOpts := common.RegisterBatchFlags(fs)
+	var specs common.ListFlag
+	fs.Var(&specs, "variant", "Variant spec suffix:key=value,... (repeatable; keys: width, height, min-width, min-height, fit, gravity, pad, target-kb, quality=85..55, quality-step).")
+	targetKB := fs.Int("target-kb", 300, "Default maximum size in kilobytes for variants without target-kb.")
+	initialQuality := fs.Int("initial-quality", 85, "Default starting mozjpeg quality.")
+	minQuality := fs.Int("min-quality", 55, "Default minimum mozjpeg quality.")
+	qualityStep := fs.Int("quality-step", 5, "Default quality decrement between attempts.")
+	srcset := fs.String("srcset", "", "Write a srcset snippet to this .json or .html file.")
+	sizes := fs.String("sizes", "100vw", "sizes attribute for the srcset snippet.")
+	encode := mozjpeg.RegisterEncodeFlags(fs)
+	sharpen := fs.String("sharpen", "", "Unsharp mask after resizing: amount,radius,threshold or auto to scale with each variant's downscale factor.")
+	naming := common.RegisterNamingFlags(fs, "{dir}/{name}-{width}w.jpg", "suffix")
+	config := common.RegisterConfigFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if printed, err := config.Apply(fs, "variants"); err != nil || printed {
+		return err
+	}
+	if err := batchOpts.Validate(); err != nil {
+		return err
+	}
+	if err := naming.Validate(); err != nil {
+		return err
+	}
+	if batchOpts.Output == "-" {
+		return fmt.Errorf("variants writes several files per image and cannot use --output -")
+	}
+	if ext := strings.ToLower(filepath.Ext(*srcset)); *srcset != "" && ext != ".json" && ext != ".html" {
+		return fmt.Errorf("srcset snippet must be a .json or .html file")
+	}
+	sharpening, err := imageutil.ParseSharpen(*sharpen)
+	if err != nil {
+		return err
+	}
+
+	defaults := compress.QualitySearch{
+		TargetBytes:    int64(*targetKB) * 1024,
+		InitialQuality: *initialQuality,
+		MinQuality:     *minQuality,
+		QualityStep:    *qualityStep,
+		Encode:         *encode,
+	}
+	if err := encode.Validate(); err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		specs = defaultSpecs
+	}
+	list := make([]variant, 0, len(specs))
+	for _, spec := range specs {
+		v, err := parseVariant(spec, defaults)
+		if err != nil {
+			return err
+		}
+		list = append(list, v)
+	}
+
+	defer batchOpts.Cleanup()
+	if err := batchOpts.PrepareOutput(); err != nil {
+		return err
+	}
+	if err := batchOpts.PrepareInput(fs.Args()); err != nil {
+		return err
+	}
+	opt := options{
+		BatchOptions: *batchOpts,
+		Variants:     list,
+		Sharpen:      sharpening,
+		Naming:       naming,
+		Srcset:       *srcset,
+		Sizes:        *sizes,
+	}
+
+	files, err := opt.Collect()
+	if err != nil {
+		return err
+	}
+	if opt.NothingToDo(files) {
+		return nil
+	}
+
+	var tc *mozjpeg.Toolchain
+	if !opt.DryRun {
+		tc, err = mozjpeg.Ensure(ctx)
+		if err != nil {
+			return fmt.Errorf("prepare mozjpeg: %w", err)
+		}
+		fmt.Printf("Using embedded mozjpeg (%s).\n", mozjpeg.Version)
+	} else {
+		fmt.Println("Running in dry-run mode. No files will be written.")
+	}
+
+	processed := make(map[string]srcsetEntry)
+	batch := opt.Batch(opt.hash())
+	batch.Naming = opt.Naming
+	err = batch.Run(ctx, files, func(ctx context.Context, src, dest string) (common.FileResult, error) {
+		res, entry, err := processFile(ctx, tc, src, dest, opt)
+		if err == nil && len(entry.Variants) > 0 {
+			processed[src] = entry
+		}
+		return res, err
+	})
+	if opt.Srcset != "" && ctx.Err() == nil {
+		entries, serr := srcsetEntries(files, processed, batch, opt)
+		if serr != nil {
+			return fmt.Errorf("write srcset: %w", serr)
+		}
+		if werr := writeSrcset(opt.Srcset, entries); werr != nil {
+			return fmt.Errorf("write srcset: %w", werr)
+		}
+		fmt.Printf("Wrote srcset snippet for %d images to %s.\n", len(entries), opt.Srcset)
+	}
+	return err
+}
+
+// srcsetEntries lists every source in files order. Sources skipped by
+// --resume or --incremental are rebuilt from the outputs the manifest
+// recorded for them, so the snippet always covers the whole set.
+func srcsetEntries(files []string, processed map[string]srcsetEntry, batch common.Batch, opt options) ([]srcsetEntry, error) {
+	manifest, err := common.OpenManifest(opt.Output, true)
+	if err != nil {
+		return nil, err
+	}
+	var entries []srcsetEntry
+	for _, src := range files {
+		if entry, ok := processed[src]; ok {
+			entries = append(entries, entry)
+			continue
+		}
+		e, ok := manifest.Lookup(batch.Key(src))
+		if !ok || len(e.Outputs) == 0 {
+			continue
+		}
+		entry := srcsetEntry{Source: src, Sizes: opt.Sizes}
+		for _, out := range e.Outputs {
+			dims, err := imageutil.Dimensions(out)
+			if err != nil {
+				return nil, err
+			}
+			entry.add(opt.Output, out, dims, fileSize(out))
+		}
+		entry.finish()
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func parseVariant(spec string, defaults compress.QualitySearch) (variant, error) {
+	suffix, rest, ok := strings.Cut(spec, ":")
+	suffix = strings.TrimSpace(suffix)
+	if !ok || suffix == "" {
+		return variant{}, fmt.Errorf("variant %q: want suffix:key=value,...", spec)
+	}
+	v := variant{Suffix: suffix, QualitySearch: defaults}
+	for _, kv := range strings.Split(rest, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return variant{}, fmt.Errorf("variant %s: %q is not key=value", suffix, kv)
+		}
+		switch key {
+		case "fit":
+			v.Bounds.Fit = value
+			continue
+		case "gravity":
+			v.Bounds.Gravity = value
+			continue
+		case "pad":
+			pad, err := imageutil.ParseHexColor(value)
+			if err != nil {
+				return variant{}, fmt.Errorf("variant %s: %w", suffix, err)
+			}
+			v.Bounds.Pad = pad
+			continue
+		}
+		if key == "quality" {
+			hi, lo, isRange := strings.Cut(value, "..")
+			initial, err := strconv.Atoi(hi)
+			if err != nil {
+				return variant{}, fmt.Errorf("variant %s: quality %q: want N or N..M", suffix, value)
+			}
+			v.InitialQuality, v.MinQuality = initial, initial
+			if isRange {
+				if v.MinQuality, err = strconv.Atoi(lo); err != nil {
+					return variant{}, fmt.Errorf("variant %s: quality %q: want N or N..M", suffix, value)
+				}
+			}
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return variant{}, fmt.Errorf("variant %s: %s must be an integer", suffix, key)
+		}
+		switch key {
+		case "width", "max-width":
+			v.Bounds.MaxWidth = n
+		case "height", "max-height":
+			v.Bounds.MaxHeight = n
+		case "min-width":
+			v.Bounds.MinWidth = n
+		case "min-height":
+			v.Bounds.MinHeight = n
+		case "target-kb":
+			v.TargetBytes = int64(n) * 1024
+		case "quality-step":
+			v.QualityStep = n
+		default:
+			return variant{}, fmt.Errorf("variant %s: unknown key %q", suffix, key)
+		}
+	}
+	if v.Bounds.Fit != "" {
+		// With a fit mode width and height are the exact output size.
+		v.Bounds.Width, v.Bounds.Height = v.Bounds.MaxWidth, v.Bounds.MaxHeight
+		v.Bounds.MaxWidth, v.Bounds.MaxHeight = 0, 0
+		if v.Bounds.Pad == (color.NRGBA{}) {
+			v.Bounds.Pad = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+		}
+	}
+	if err := v.Bounds.Validate(); err != nil {
+		return variant{}, fmt.Errorf("variant %s: %w", suffix, err)
+	}
+	if err := v.QualitySearch.Validate(); err != nil {
+		return variant{}, fmt.Errorf("variant %s: %w", suffix, err)
+	}
+	return v, nil
+}
+
+func (o options) hash() string {
+	return common.HashOptions("variants", o.Variants, o.Sharpen, o.Naming.Template, o.Naming.Flatten)
+}
+
+func processFile(ctx context.Context, tc *mozjpeg.Toolchain, src, dest string, opt options) (common.FileResult, srcsetEntry, error) {
+	entry := srcsetEntry{Source: src, Sizes: opt.Sizes}
+	img, err := imageutil.Load(src)
+	if err != nil {
+		return common.FileResult{}, entry, err
+	}
+
+	base := filepath.Base(src)
+	res := common.FileResult{Original: [2]int{img.Bounds().Dx(), img.Bounds().Dy()}}
+	produced := make(map[string]string, len(opt.Variants))
+	encoded, maxed := 0, false
+
+	for _, v := range opt.Variants {
+		info := imageutil.Resize(img, v.Bounds)
+		vars := common.NameVars{
+			Width:  info.Processed[0],
+			Height: info.Processed[1],
+			Extra:  map[string]string{"suffix": v.Suffix},
+		}
+		out, named, err := opt.Naming.Resolve(opt.Output, src, dest, vars)
+		if err != nil {
+			return res, entry, err
+		}
+		if prev, dup := produced[out]; dup {
+			fmt.Printf("[SKIP] %s %s: same output as %s.\n", base, v.Suffix, prev)
+			continue
+		}
+		produced[out] = v.Suffix
+
+		note := imageutil.FormatDimensionNote(res.Original, info.Processed, v.Bounds)
+		for _, w := range imageutil.DimensionWarnings(res.Original, info.Processed, v.Bounds) {
+			res.Warnings = append(res.Warnings, v.Suffix+": "+w)
+		}
+
+		if named {
+			if keep, err := opt.KeepExisting(out); err != nil {
+				return res, entry, err
+			} else if keep {
+				fmt.Printf("[SKIP] %s exists (use --overwrite).\n", out)
+				entry.add(opt.Output, out, info.Processed, fileSize(out))
+				res.Outputs = append(res.Outputs, out)
+				continue
+			}
+		}
+
+		if opt.DryRun {
+			fmt.Printf("[DRY] %s -> %s (%s) target=%dKB quality=%d..%d step=%d\n",
+				base,
+				opt.ShownDest(src, out),
+				note,
+				v.TargetBytes/1024,
+				v.InitialQuality,
+				v.MinQuality,
+				v.QualityStep,
+			)
+			entry.add(opt.Output, out, info.Processed, 0)
+			continue
+		}
+
+		vr, err := encodeVariant(ctx, tc, info, src, out, named, v, opt)
+		if err != nil {
+			return res, entry, fmt.Errorf("%s: %w", v.Suffix, err)
+		}
+		if !named {
+			vars.Quality = vr.Quality
+			if out, _, err = opt.Naming.Resolve(opt.Output, src, dest, vars); err != nil {
+				return res, entry, err
+			}
+			if keep, err := opt.KeepExisting(out); err != nil {
+				return res, entry, err
+			} else if keep {
+				os.Remove(opt.Naming.Provisional(opt.Output, src))
+				fmt.Printf("[SKIP] %s exists (use --overwrite).\n", out)
+				entry.add(opt.Output, out, info.Processed, fileSize(out))
+				res.Outputs = append(res.Outputs, out)
+				continue
+			}
+			if err := opt.Naming.Place(opt.Naming.Provisional(opt.Output, src), out); err != nil {
+				return res, entry, err
+			}
+		}
+		fmt.Printf("[%s] %s -> %s (%s) q=%d size=%.1fKB\n",
+			vr.Status,
+			base,
+			opt.ShownDest(src, out),
+			note,
+			vr.Quality,
+			float64(vr.BytesOut)/1024,
+		)
+		encoded++
+		maxed = maxed || vr.Status == "MAXED"
+		res.Outputs = append(res.Outputs, out)
+		res.Attempts += vr.Attempts
+		res.BytesOut += vr.BytesOut
+		if info.Processed[0]*info.Processed[1] >= res.Processed[0]*res.Processed[1] {
+			res.Processed = info.Processed
+			res.Quality = vr.Quality
+			res.Dest = out
+		}
+		entry.add(opt.Output, out, info.Processed, vr.BytesOut)
+	}
+
+	switch {
+	case opt.DryRun:
+		res.Status = "DRY"
+	case encoded == 0:
+		res.Status = "SKIP"
+	case maxed:
+		res.Status = "MAXED"
+	default:
+		res.Status = "OK"
+	}
+	entry.finish()
+	return res, entry, nil
+}
+
+// encodeVariant writes to out, or to the provisional path when the name
+// still waits for {quality}.
+func encodeVariant(ctx context.Context, tc *mozjpeg.Toolchain, info *imageutil.ImageInfo, src, out string, named bool, v variant, opt options) (common.FileResult, error) {
+	ppmPath, err := imageutil.WritePPM(opt.Sharpen.Apply(info.Image, info.Scale()))
+	if err != nil {
+		return common.FileResult{}, fmt.Errorf("write ppm: %w", err)
+	}
+	defer os.Remove(ppmPath)
+	if !named {
+		out = opt.Naming.Provisional(opt.Output, src)
+	}
+	res, err := compress.SearchQuality(ctx, tc, ppmPath, out, v.QualitySearch)
+	if err != nil && !named {
+		os.Remove(out)
+	}
+	return res, err
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}